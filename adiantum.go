@@ -2,105 +2,195 @@ package adiantum // import "lukechampine.com/adiantum"
 
 import (
 	"bytes"
-	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
 	"math/bits"
 
-	"golang.org/x/crypto/poly1305"
 	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/internal/hbshconfig"
+	"lukechampine.com/adiantum/internal/poly1305"
 	"lukechampine.com/adiantum/internal/xchacha"
 	"lukechampine.com/adiantum/nh"
 )
 
+// MaxTweakSize is the maximum size of an Adiantum tweak, as specified in the
+// Adiantum paper.
+const MaxTweakSize = 32
+
 // hashNHPoly1305 implements hbsh.Hash with NH and Poly1305.
 type hashNHPoly1305 struct {
-	keyT  [32]byte
-	keyM  [32]byte
-	keyNH [1072]byte
+	keyT  poly1305.Key
+	keyM  poly1305.Key
+	keyNH [nh.KeySize]byte
 }
 
 // Sum implements hbsh.Hash.
 func (h *hashNHPoly1305) Sum(dst, msg, tweak []byte) []byte {
-	// poly1305 hash 8*len(msg) and tweak with keyT
-	tweakBuf := make([]byte, 16+24)
-	binary.LittleEndian.PutUint64(tweakBuf[:8], uint64(8*len(msg)))
-	var outT [16]byte
-	poly1305.Sum(&outT, append(tweakBuf[:16], tweak...), &h.keyT)
-
-	// NH hash message in chunks of up to 1024 bytes, then poly1305 those hashes
-	// with keyM
-	mac := poly1305.New(&h.keyM)
-	var outNH [32]byte
-	for len(msg) >= 1024 {
-		nh.Sum(&outNH, msg[:1024], h.keyNH[:])
-		mac.Write(outNH[:])
-		msg = msg[1024:]
-	}
-	// handle final (incomplete) chunk, if it exists
-	if len(msg) > 0 {
-		// if necessary, pad to multiple of 16 bytes
-		if len(msg)%16 != 0 {
-			var pad [1024]byte
-			n := copy(pad[:], msg)
-			n += 16 - (n % 16)
-			msg = pad[:n]
+	sum := addHashes(h.sumT(len(msg), tweak), h.sumM(msg))
+	return append(dst[:0], sum[:]...)
+}
+
+// sumT poly1305 hashes 8*msgLen and tweak with keyT.
+func (h *hashNHPoly1305) sumT(msgLen int, tweak []byte) (outT [16]byte) {
+	var lenBuf [16]byte
+	binary.LittleEndian.PutUint64(lenBuf[:8], uint64(8*msgLen))
+	var macT poly1305.MAC
+	macT.Init(&h.keyT)
+	macT.Write(lenBuf[:])
+	macT.Write(tweak)
+	macT.Sum(outT[:0])
+	return
+}
+
+// sumM NH hashes msg, then poly1305 hashes the NH hashes with keyM.
+func (h *hashNHPoly1305) sumM(msg []byte) (outM [16]byte) {
+	var mac poly1305.MAC
+	mac.Init(&h.keyM)
+	// hash up to 16 chunks at a time, so that the hashes fit on the stack
+	var buf [16 * nh.HashSize]byte
+	for len(msg) > 0 {
+		n := len(msg)
+		if n > 16*nh.ChunkSize {
+			n = 16 * nh.ChunkSize
 		}
-		nh.Sum(&outNH, msg, h.keyNH[:])
-		mac.Write(outNH[:])
+		hashes, _ := nh.Hash(buf[:0], msg[:n], h.keyNH[:])
+		mac.Write(hashes)
+		msg = msg[n:]
 	}
-	var outM [16]byte
 	mac.Sum(outM[:0])
+	return
+}
 
-	// return the sum of the hashes
-	sum := addHashes(outT, outM)
+// WithTweak implements hbsh.TweakPrecomputer. Since the tweak is hashed
+// together with the message length, the returned hash caches the tweak hash
+// for the most recently used length only: a run of messages of the same length
+// (such as fixed-size records) hashes the tweak once, while each change of
+// length hashes it again and replaces the cached value.
+func (h *hashNHPoly1305) WithTweak(tweak []byte) hbsh.FixedTweakHash {
+	return &tweakedNHPoly1305{
+		h:      h,
+		tweak:  append([]byte(nil), tweak...),
+		msgLen: -1,
+	}
+}
+
+type tweakedNHPoly1305 struct {
+	h      *hashNHPoly1305
+	tweak  []byte
+	msgLen int
+	outT   [16]byte
+}
+
+func (t *tweakedNHPoly1305) Sum(dst, msg []byte) []byte {
+	if len(msg) != t.msgLen {
+		t.outT = t.h.sumT(len(msg), t.tweak)
+		t.msgLen = len(msg)
+	}
+	sum := addHashes(t.outT, t.h.sumM(msg))
 	return append(dst[:0], sum[:]...)
 }
 
+// Wipe implements hbsh.Wiper.
+func (h *hashNHPoly1305) Wipe() { *h = hashNHPoly1305{} }
+
+// MaxTweakSize implements hbsh.TweakLimiter.
+func (h *hashNHPoly1305) MaxTweakSize() int { return MaxTweakSize }
+
 type chachaStream struct {
 	key    []byte
 	rounds int
 }
 
 func (s *chachaStream) XORKeyStream(msg, nonce []byte) {
-	nonceBuf := make([]byte, 24)
-	n := copy(nonceBuf, nonce)
+	var nonceBuf [24]byte
+	n := copy(nonceBuf[:], nonce)
 	nonceBuf[n] = 1
-	xchacha.XORKeyStream(msg, msg, nonceBuf, s.key, s.rounds)
+	xchacha.XORKeyStream(msg, msg, nonceBuf[:], s.key, s.rounds)
 }
 
-func makeAdiantum(key []byte, chachaRounds int) (hbsh.StreamCipher, cipher.Block, hbsh.TweakableHash) {
-	if len(key) != xchacha.KeySize {
-		panic("adiantum: key must be 32 bytes long")
+// Wipe implements hbsh.Wiper.
+func (s *chachaStream) Wipe() {
+	for i := range s.key {
+		s.key[i] = 0
 	}
+}
+
+// chachaLanes is a chachaStream that implements hbsh.MultiStreamCipher.
+type chachaLanes struct {
+	*chachaStream
+}
+
+// withLanes returns s, as a chachaLanes if XORKeyStreams is vectorized; the
+// generic XORKeyStreams is slower than encrypting one sector at a time.
+func withLanes(s *chachaStream) hbsh.StreamCipher {
+	if xchacha.HasLanes {
+		return chachaLanes{s}
+	}
+	return s
+}
+
+func (s chachaLanes) XORKeyStreams(msgs, nonces [][]byte) {
+	var nonceBufs [8][24]byte
+	var nonceSlices [8][]byte
+	for len(msgs) > 0 {
+		n := len(msgs)
+		if n > len(nonceBufs) {
+			n = len(nonceBufs)
+		}
+		for i := 0; i < n; i++ {
+			nonceBufs[i] = [24]byte{}
+			c := copy(nonceBufs[i][:], nonces[i])
+			nonceBufs[i][c] = 1
+			nonceSlices[i] = nonceBufs[i][:]
+		}
+		xchacha.XORKeyStreams(msgs[:n], nonceSlices[:n], s.key, s.rounds)
+		msgs, nonces = msgs[n:], nonces[n:]
+	}
+}
+
+// A Config specifies the parameters of an Adiantum variant.
+type Config = hbshconfig.Config
+
+func makeAdiantum(key []byte, c Config) (hbsh.StreamCipher, cipher.Block, hbsh.TweakableHash) {
+	c = hbshconfig.Check("adiantum", key, c)
 	// create stream cipher and derive block+hash keys
-	stream := &chachaStream{key, chachaRounds}
-	keyBuf := bytes.NewBuffer(make([]byte, 32+16+16+1072))
+	stream := &chachaStream{append([]byte(nil), key...), c.Rounds}
+	keyBuf := bytes.NewBuffer(make([]byte, c.BlockKeySize+16+16+nh.KeySize))
 	stream.XORKeyStream(keyBuf.Bytes(), nil)
-	block, _ := aes.NewCipher(keyBuf.Next(32))
+	block := hbshconfig.NewBlock("adiantum", c, keyBuf.Next(c.BlockKeySize))
 	hash := new(hashNHPoly1305)
-	copy(hash.keyT[:16], keyBuf.Next(16))
-	copy(hash.keyM[:16], keyBuf.Next(16))
-	copy(hash.keyNH[:], keyBuf.Next(1072)) // enough to hash a 1024-byte message
-	return stream, block, hash
+	var keyT, keyM [32]byte
+	copy(keyT[:16], keyBuf.Next(16))
+	copy(keyM[:16], keyBuf.Next(16))
+	hash.keyT.Init(&keyT)
+	hash.keyM.Init(&keyM)
+	copy(hash.keyNH[:], keyBuf.Next(nh.KeySize))
+	return withLanes(stream), block, hash
 }
 
 // New8 returns an Adiantum cipher with the specified key, using XChaCha8 as the
 // stream cipher. The key must be 32 bytes.
 func New8(key []byte) *hbsh.HBSH {
-	return hbsh.New(makeAdiantum(key, 8))
+	return hbsh.New(makeAdiantum(key, Config{Rounds: 8}))
 }
 
 // New returns an Adiantum cipher with the specified key. The key must be 32
 // bytes.
 func New(key []byte) *hbsh.HBSH {
-	return hbsh.New(makeAdiantum(key, 12))
+	return hbsh.New(makeAdiantum(key, Config{Rounds: 12}))
 }
 
 // New20 returns an Adiantum cipher with the specified key, using XChaCha20 as
 // the stream cipher. The key must be 32 bytes.
 func New20(key []byte) *hbsh.HBSH {
-	return hbsh.New(makeAdiantum(key, 20))
+	return hbsh.New(makeAdiantum(key, Config{Rounds: 20}))
+}
+
+// NewWithConfig returns an Adiantum variant with the specified key and
+// parameters. The key must be 32 bytes. The block cipher key is derived first,
+// followed by the hash keys, so the zero Config is equivalent to New.
+func NewWithConfig(key []byte, c Config) *hbsh.HBSH {
+	return hbsh.New(makeAdiantum(key, c))
 }
 
 func addHashes(x, y [16]byte) [16]byte {
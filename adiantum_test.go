@@ -1,85 +1,331 @@
 package adiantum
 
 import (
-	"encoding/hex"
-	"encoding/json"
-	"io/ioutil"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
 	"testing"
 
 	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/internal/ctaes"
+	"lukechampine.com/adiantum/internal/kat"
 )
 
-func fromHex(s string) []byte {
-	b, _ := hex.DecodeString(s)
-	return b
-}
-
-type testVector struct {
-	Description string `json:"description"`
-	Input       struct {
-		Key   string `json:"key_hex"`
-		Tweak string `json:"tweak_hex"`
-	} `json:"input"`
-	Plaintext  string `json:"plaintext_hex"`
-	Ciphertext string `json:"ciphertext_hex"`
-}
-
-func readTestVectors(t *testing.T, filename string) []testVector {
+func readTestVectors(t *testing.T, filename string) []kat.Vector {
 	t.Helper()
-	js, err := ioutil.ReadFile(filename)
+	tests, err := kat.Load(filename)
 	if err != nil {
 		t.Fatal(err)
 	}
-	var tests []testVector
-	if err := json.Unmarshal(js, &tests); err != nil {
-		t.Fatal(err)
-	}
 	return tests
 }
 
-func TestAdiantum_XChaCha8_32_AES256(t *testing.T) {
-	tests := readTestVectors(t, "testdata/Adiantum_XChaCha8_32_AES256.json")
-	for i, test := range tests {
-		c := New8(fromHex(test.Input.Key))
-		ciphertext := c.Encrypt(fromHex(test.Plaintext), fromHex(test.Input.Tweak))
-		if hex.EncodeToString(ciphertext) != test.Ciphertext {
-			t.Fatalf("%v (%v): Encryption failed:\nexp: %v\ngot: %x", test.Description, i, test.Ciphertext, ciphertext)
+func testVectors(t *testing.T, filename string, fn func([]byte) *hbsh.HBSH) {
+	t.Helper()
+	for i, test := range readTestVectors(t, filename) {
+		c := fn(test.Input.Key)
+		ciphertext := c.Encrypt(append([]byte(nil), test.Plaintext...), test.Input.Tweak)
+		if !bytes.Equal(ciphertext, test.Ciphertext) {
+			t.Fatalf("%v (%v): Encryption failed:\nexp: %x\ngot: %x", test.Description, i, test.Ciphertext, ciphertext)
 		}
-		plaintext := c.Decrypt(fromHex(test.Ciphertext), fromHex(test.Input.Tweak))
-		if hex.EncodeToString(plaintext) != test.Plaintext {
-			t.Fatalf("%v (%v): Decryption failed:\nexp: %v\ngot: %x", test.Description, i, test.Plaintext, plaintext)
+		plaintext := c.Decrypt(append([]byte(nil), test.Ciphertext...), test.Input.Tweak)
+		if !bytes.Equal(plaintext, test.Plaintext) {
+			t.Fatalf("%v (%v): Decryption failed:\nexp: %x\ngot: %x", test.Description, i, test.Plaintext, plaintext)
 		}
 	}
 }
 
+func TestAdiantum_XChaCha8_32_AES256(t *testing.T) {
+	testVectors(t, "testdata/Adiantum_XChaCha8_32_AES256.json", New8)
+}
+
 func TestAdiantum_XChaCha12_32_AES256(t *testing.T) {
-	tests := readTestVectors(t, "testdata/Adiantum_XChaCha12_32_AES256.json")
-	for i, test := range tests {
-		c := New(fromHex(test.Input.Key))
-		ciphertext := c.Encrypt(fromHex(test.Plaintext), fromHex(test.Input.Tweak))
-		if hex.EncodeToString(ciphertext) != test.Ciphertext {
-			t.Fatalf("%v (%v): Encryption failed:\nexp: %v\ngot: %x", test.Description, i, test.Ciphertext, ciphertext)
+	testVectors(t, "testdata/Adiantum_XChaCha12_32_AES256.json", New)
+}
+
+func TestAdiantum_XChaCha20_32_AES256(t *testing.T) {
+	testVectors(t, "testdata/Adiantum_XChaCha20_32_AES256.json", New20)
+}
+
+//go:generate go run ./cmd/genvectors -cipher Adiantum -rounds 8 -aes 128 -lengths 16,17,31,32,63,64,255,256,1024,4096 -seed 8 -o testdata/Adiantum_XChaCha8_32_AES128.json
+//go:generate go run ./cmd/genvectors -cipher Adiantum -rounds 12 -aes 128 -lengths 16,17,31,32,63,64,255,256,1024,4096 -seed 12 -o testdata/Adiantum_XChaCha12_32_AES128.json
+//go:generate go run ./cmd/genvectors -cipher Adiantum -rounds 20 -aes 128 -lengths 16,17,31,32,63,64,255,256,1024,4096 -seed 20 -o testdata/Adiantum_XChaCha20_32_AES128.json
+
+func TestAES128(t *testing.T) {
+	// these vectors were generated from the reference implementation
+	for _, rounds := range []int{8, 12, 20} {
+		testVectors(t, fmt.Sprintf("testdata/Adiantum_XChaCha%v_32_AES128.json", rounds), func(key []byte) *hbsh.HBSH {
+			return NewWithConfig(key, Config{Rounds: rounds, BlockKeySize: 16})
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	block := make([]byte, 512)
+	rand.Read(block)
+	tweak := make([]byte, 12)
+
+	// the zero Config is equivalent to New
+	exp := New(key).Encrypt(append([]byte(nil), block...), tweak)
+	if got := NewWithConfig(key, Config{}).Encrypt(append([]byte(nil), block...), tweak); !bytes.Equal(got, exp) {
+		t.Fatal("zero Config does not match New")
+	}
+
+	// a custom block cipher receives BlockKeySize bytes
+	var keyLen int
+	c := NewWithConfig(key, Config{
+		BlockKeySize: 24,
+		NewBlock: func(k []byte) (cipher.Block, error) {
+			keyLen = len(k)
+			return aes.NewCipher(k)
+		},
+	})
+	if keyLen != 24 {
+		t.Fatalf("expected 24-byte block cipher key, got %v", keyLen)
+	}
+	ciphertext := c.Encrypt(append([]byte(nil), block...), tweak)
+	if bytes.Equal(ciphertext, exp) {
+		t.Fatal("AES-192 variant should not match AES-256")
+	} else if !bytes.Equal(c.Decrypt(ciphertext, tweak), block) {
+		t.Fatal("AES-192 variant did not round-trip")
+	}
+
+	// invalid configurations
+	for _, c := range []Config{
+		{Rounds: 10},
+		{BlockKeySize: -1},
+		{BlockKeySize: 20},
+		{NewBlock: func([]byte) (cipher.Block, error) { return des.NewCipher(make([]byte, 8)) }},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic for %+v", c)
+				}
+			}()
+			NewWithConfig(key, c)
+		}()
+	}
+}
+
+func TestSoftwareAES(t *testing.T) {
+	// force the constant-time fallback used on CPUs without AES instructions
+	defer func(f bool) { ctaes.Force = f }(ctaes.Force)
+	ctaes.Force = true
+	testVectors(t, "testdata/Adiantum_XChaCha8_32_AES256.json", New8)
+	testVectors(t, "testdata/Adiantum_XChaCha12_32_AES256.json", New)
+	testVectors(t, "testdata/Adiantum_XChaCha20_32_AES256.json", New20)
+}
+
+func TestConstantTimeAES(t *testing.T) {
+	if ctaes.Force {
+		t.Skip("constant-time AES is already forced")
+	}
+	_, block, _ := makeAdiantum(make([]byte, 32), Config{ConstantTimeAES: true})
+	if _, ok := block.(hbsh.Wiper); !ok {
+		t.Fatalf("ConstantTimeAES selected %T", block)
+	}
+	for _, rounds := range []int{8, 12, 20} {
+		rounds := rounds
+		testVectors(t, fmt.Sprintf("testdata/Adiantum_XChaCha%v_32_AES256.json", rounds), func(key []byte) *hbsh.HBSH {
+			return NewWithConfig(key, Config{Rounds: rounds, ConstantTimeAES: true})
+		})
+	}
+}
+
+//go:generate go run ./cmd/genvectors -cipher Adiantum -rounds 12 -aes 256 -lengths 16,17,255,512,4096 -tweaks 0,24,32 -seed 24 -o testdata/Adiantum_XChaCha12_32_AES256_Tweaks.json
+
+func TestTweakSizes(t *testing.T) {
+	// the test vectors include empty, 17-byte, 24-byte, and 32-byte tweaks;
+	// the kernel uses 24-byte tweaks with fscrypt. The vectors in the second
+	// file were generated from the reference implementation.
+	tested := make(map[int]bool)
+	for _, filename := range []string{
+		"testdata/Adiantum_XChaCha12_32_AES256.json",
+		"testdata/Adiantum_XChaCha12_32_AES256_Tweaks.json",
+	} {
+		for i, test := range readTestVectors(t, filename) {
+			tweak := test.Input.Tweak
+			c := New(test.Input.Key)
+			ciphertext, err := c.EncryptChecked(append([]byte(nil), test.Plaintext...), tweak)
+			if err != nil {
+				t.Fatalf("%v (%v): %v", test.Description, i, err)
+			} else if !bytes.Equal(ciphertext, test.Ciphertext) {
+				t.Fatalf("%v (%v): Encryption failed:\nexp: %x\ngot: %x", test.Description, i, test.Ciphertext, ciphertext)
+			}
+			plaintext, err := c.DecryptChecked(append([]byte(nil), test.Ciphertext...), tweak)
+			if err != nil {
+				t.Fatalf("%v (%v): %v", test.Description, i, err)
+			} else if !bytes.Equal(plaintext, test.Plaintext) {
+				t.Fatalf("%v (%v): Decryption failed:\nexp: %x\ngot: %x", test.Description, i, test.Plaintext, plaintext)
+			}
+			if got := c.WithTweak(tweak).Encrypt(append([]byte(nil), test.Plaintext...)); !bytes.Equal(got, test.Ciphertext) {
+				t.Fatalf("%v (%v): WithTweak encryption failed:\nexp: %x\ngot: %x", test.Description, i, test.Ciphertext, got)
+			}
+			tested[len(tweak)] = true
+		}
+	}
+	for _, n := range []int{0, 24, 32} {
+		if !tested[n] {
+			t.Fatalf("test vectors should include %v-byte tweaks", n)
 		}
-		plaintext := c.Decrypt(fromHex(test.Ciphertext), fromHex(test.Input.Tweak))
-		if hex.EncodeToString(plaintext) != test.Plaintext {
-			t.Fatalf("%v (%v): Decryption failed:\nexp: %v\ngot: %x", test.Description, i, test.Plaintext, plaintext)
+	}
+
+	c := New(make([]byte, 32))
+	// error paths
+	if c.MaxTweakSize() != MaxTweakSize {
+		t.Fatalf("expected MaxTweakSize of %v, got %v", MaxTweakSize, c.MaxTweakSize())
+	}
+	if _, err := c.EncryptChecked(make([]byte, 15), nil); err != hbsh.ErrBlockTooShort {
+		t.Fatal("expected ErrBlockTooShort, got", err)
+	} else if _, err := c.DecryptChecked(make([]byte, 15), nil); err != hbsh.ErrBlockTooShort {
+		t.Fatal("expected ErrBlockTooShort, got", err)
+	}
+	if _, err := c.EncryptChecked(make([]byte, 16), make([]byte, MaxTweakSize+1)); err != hbsh.ErrTweakTooLong {
+		t.Fatal("expected ErrTweakTooLong, got", err)
+	} else if _, err := c.DecryptChecked(make([]byte, 16), make([]byte, MaxTweakSize+1)); err != hbsh.ErrTweakTooLong {
+		t.Fatal("expected ErrTweakTooLong, got", err)
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	ctors := []struct {
+		name string
+		fn   func([]byte) *hbsh.HBSH
+	}{
+		{"XChaCha8", New8},
+		{"XChaCha12", New},
+		{"XChaCha20", New20},
+	}
+	for _, ctor := range ctors {
+		c := ctor.fn(make([]byte, 32))
+		for _, size := range []int{16, 31, 512, 1536, 4096} {
+			block := make([]byte, size)
+			tweak := make([]byte, 32)
+			if n := testing.AllocsPerRun(10, func() { c.Encrypt(block, tweak) }); n > 0 {
+				t.Errorf("%v: Encrypt(%v) allocated %v times", ctor.name, size, n)
+			}
+			if n := testing.AllocsPerRun(10, func() { c.Decrypt(block, tweak) }); n > 0 {
+				t.Errorf("%v: Decrypt(%v) allocated %v times", ctor.name, size, n)
+			}
+			// AllocsPerRun sets GOMAXPROCS to 1, so these take the serial path
+			sectors := make([]byte, size*10)
+			if n := testing.AllocsPerRun(10, func() { c.EncryptSectors(sectors, size, 0) }); n > 0 {
+				t.Errorf("%v: EncryptSectors(%v) allocated %v times", ctor.name, size, n)
+			}
+			if n := testing.AllocsPerRun(10, func() { c.DecryptSectors(sectors, size, 0) }); n > 0 {
+				t.Errorf("%v: DecryptSectors(%v) allocated %v times", ctor.name, size, n)
+			}
 		}
 	}
 }
 
-func TestAdiantum_XChaCha20_32_AES256(t *testing.T) {
-	tests := readTestVectors(t, "testdata/Adiantum_XChaCha20_32_AES256.json")
-	for i, test := range tests {
-		c := New20(fromHex(test.Input.Key))
-		ciphertext := c.Encrypt(fromHex(test.Plaintext), fromHex(test.Input.Tweak))
-		if hex.EncodeToString(ciphertext) != test.Ciphertext {
-			t.Fatalf("%v (%v): Encryption failed:\nexp: %v\ngot: %x", test.Description, i, test.Ciphertext, ciphertext)
+func TestSectors(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	c := New(key)
+	// EncryptSectors groups sectors only if the stream has vectorized lanes;
+	// test both paths regardless
+	stream, block, thash := makeAdiantum(key, Config{})
+	s := baseStream(stream)
+	paths := []struct {
+		name string
+		h    *hbsh.HBSH
+	}{
+		{"grouped", hbsh.New(chachaLanes{s}, block, thash)},
+		{"per-sector", hbsh.New(s, block, thash)},
+	}
+	for _, sectorSize := range []int{16, 512, 4096} {
+		for _, numSectors := range []int{1, 3, 64} {
+			plaintext := make([]byte, sectorSize*numSectors)
+			rand.Read(plaintext)
+
+			// encrypt serially
+			exp := append([]byte(nil), plaintext...)
+			tweak := make([]byte, 8)
+			for i := 0; i < numSectors; i++ {
+				binary.LittleEndian.PutUint64(tweak, 100+uint64(i))
+				c.Encrypt(exp[i*sectorSize:][:sectorSize], tweak)
+			}
+
+			for _, path := range paths {
+				buf := append([]byte(nil), plaintext...)
+				path.h.EncryptSectors(buf, sectorSize, 100)
+				if !bytes.Equal(buf, exp) {
+					t.Fatalf("%v: EncryptSectors (%v x %v) does not match serial encryption", path.name, numSectors, sectorSize)
+				}
+				path.h.DecryptSectors(buf, sectorSize, 100)
+				if !bytes.Equal(buf, plaintext) {
+					t.Fatalf("%v: DecryptSectors (%v x %v) did not recover plaintext", path.name, numSectors, sectorSize)
+				}
+			}
 		}
-		plaintext := c.Decrypt(fromHex(test.Ciphertext), fromHex(test.Input.Tweak))
-		if hex.EncodeToString(plaintext) != test.Plaintext {
-			t.Fatalf("%v (%v): Decryption failed:\nexp: %v\ngot: %x", test.Description, i, test.Plaintext, plaintext)
+	}
+}
+
+// baseStream returns the chachaStream underlying stream, which may be wrapped
+// in chachaLanes.
+func baseStream(stream hbsh.StreamCipher) *chachaStream {
+	if l, ok := stream.(chachaLanes); ok {
+		return l.chachaStream
+	}
+	return stream.(*chachaStream)
+}
+
+func TestWipe(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	stream, block, thash := makeAdiantum(key, Config{NewBlock: ctaes.NewCipher})
+	if !hbsh.New(stream, block, thash).Wipe() {
+		t.Fatal("Wipe did not wipe every primitive")
+	}
+	if s := baseStream(stream); !bytes.Equal(s.key, make([]byte, 32)) {
+		t.Error("stream key was not wiped")
+	}
+	if h := thash.(*hashNHPoly1305); *h != (hashNHPoly1305{}) {
+		t.Error("hash keys were not wiped")
+	}
+	if bytes.Equal(key, make([]byte, 32)) {
+		t.Error("Wipe modified the caller's key")
+	}
+}
+
+func TestWithTweak(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	c := New(key)
+	for _, tweakSize := range []int{0, 12, 32} {
+		tweak := make([]byte, tweakSize)
+		rand.Read(tweak)
+		tc := c.WithTweak(tweak)
+		// alternate lengths, so that any cached state is both reused and replaced
+		for _, size := range []int{16, 16, 100, 16, 4096, 4096, 1040} {
+			plaintext := make([]byte, size)
+			rand.Read(plaintext)
+			exp := c.Encrypt(append([]byte(nil), plaintext...), tweak)
+			ciphertext := tc.Encrypt(append([]byte(nil), plaintext...))
+			if !bytes.Equal(ciphertext, exp) {
+				t.Fatalf("WithTweak(%v).Encrypt(%v) does not match Encrypt", tweakSize, size)
+			}
+			if !bytes.Equal(tc.Decrypt(ciphertext), plaintext) {
+				t.Fatalf("WithTweak(%v).Decrypt(%v) did not recover plaintext", tweakSize, size)
+			}
 		}
 	}
+	// modifying the tweak afterwards must not affect the tweaked cipher
+	tweak := make([]byte, 8)
+	tc := c.WithTweak(tweak)
+	exp := c.Encrypt(make([]byte, 32), tweak)
+	tweak[0] = 1
+	if !bytes.Equal(tc.Encrypt(make([]byte, 32)), exp) {
+		t.Fatal("WithTweak did not copy its tweak")
+	}
 }
 
 func BenchmarkAdiantum(b *testing.B) {
@@ -113,3 +359,70 @@ func BenchmarkAdiantum(b *testing.B) {
 	b.Run("XChaCha20_Encrypt", runEncrypt(New20(make([]byte, 32))))
 	b.Run("XChaCha20_Decrypt", runDecrypt(New20(make([]byte, 32))))
 }
+
+func BenchmarkSectors(b *testing.B) {
+	// run with -cpu to observe scaling with GOMAXPROCS
+	c := New(make([]byte, 32))
+	buf := make([]byte, 1<<20)
+	b.Run("Encrypt", func(b *testing.B) {
+		b.SetBytes(int64(len(buf)))
+		for i := 0; i < b.N; i++ {
+			c.EncryptSectors(buf, 4096, 0)
+		}
+	})
+	b.Run("Decrypt", func(b *testing.B) {
+		b.SetBytes(int64(len(buf)))
+		for i := 0; i < b.N; i++ {
+			c.DecryptSectors(buf, 4096, 0)
+		}
+	})
+}
+
+// BenchmarkSectorGroups compares the two paths of EncryptSectors: sectors
+// grouped for xchacha.XORKeyStreams, and one sector at a time. New takes the
+// grouped path only if xchacha.HasLanes.
+func BenchmarkSectorGroups(b *testing.B) {
+	stream, block, thash := makeAdiantum(make([]byte, 32), Config{})
+	s := baseStream(stream)
+	paths := []struct {
+		name   string
+		stream hbsh.StreamCipher
+	}{
+		{"grouped", chachaLanes{s}},
+		{"per-sector", s},
+	}
+	for _, path := range paths {
+		c := hbsh.New(path.stream, block, thash)
+		for _, size := range []int{512, 4096} {
+			size := size
+			buf := make([]byte, 64*size)
+			b.Run(fmt.Sprintf("%v/sector=%v", path.name, size), func(b *testing.B) {
+				b.SetBytes(int64(len(buf)))
+				for i := 0; i < b.N; i++ {
+					c.EncryptSectors(buf, size, 0)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkWithTweak(b *testing.B) {
+	// short records under one tweak, where hashing the tweak is a significant
+	// fraction of the work
+	c := New(make([]byte, 32))
+	block := make([]byte, 64)
+	tweak := make([]byte, 32)
+	b.Run("Encrypt", func(b *testing.B) {
+		b.SetBytes(int64(len(block)))
+		for i := 0; i < b.N; i++ {
+			c.Encrypt(block, tweak)
+		}
+	})
+	b.Run("WithTweak", func(b *testing.B) {
+		tc := c.WithTweak(tweak)
+		b.SetBytes(int64(len(block)))
+		for i := 0; i < b.N; i++ {
+			tc.Encrypt(block)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package adiantum
+
+import (
+	"crypto/cipher"
+
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/internal/hbshconfig"
+	"lukechampine.com/adiantum/internal/xchacha"
+	"lukechampine.com/adiantum/nh"
+)
+
+// swapBlock is a cipher.Block that can be replaced without rebuilding the HBSH
+// that uses it.
+type swapBlock struct {
+	cipher.Block
+}
+
+// Agile is an Adiantum cipher optimized for frequent key changes, such as when
+// every file has its own key. Rekey reuses the cipher's memory, and derives
+// only the block cipher and Poly1305 keys; the 1072-byte NH key is derived
+// lazily, as far as each message requires, by continuing the same key stream.
+// A cipher used only for short messages thus never derives most of it.
+//
+// Agile produces the same ciphertexts as NewWithConfig with the same key and
+// Config. Like hbsh.HBSH, it is not safe for concurrent use.
+type Agile struct {
+	c      Config
+	key    [32]byte
+	stream chachaStream
+	block  swapBlock
+	hash   hashNHPoly1305
+	h      *hbsh.HBSH
+
+	// ks generates the remainder of the key stream, and nhLen is the number of
+	// bytes of hash.keyNH derived from it so far
+	ks    xchacha.Stream
+	nhLen int
+}
+
+// NewAgile returns an Agile cipher with the specified key and parameters. The
+// key must be 32 bytes.
+func NewAgile(key []byte, c Config) *Agile {
+	a := &Agile{c: hbshconfig.Check("adiantum", key, c)}
+	a.stream = chachaStream{a.key[:], a.c.Rounds}
+	a.h = hbsh.New(withLanes(&a.stream), &a.block, &a.hash)
+	a.Rekey(key)
+	return a
+}
+
+// Rekey replaces the key of a. The key must be 32 bytes.
+func (a *Agile) Rekey(key []byte) {
+	if len(key) != xchacha.KeySize {
+		panic("adiantum: key must be 32 bytes long")
+	}
+	copy(a.key[:], key)
+	var nonce [xchacha.NonceSize]byte
+	nonce[0] = 1
+	a.ks.Init(nonce[:], a.key[:], a.c.Rounds)
+
+	// the block cipher and Poly1305 keys precede the NH key in the key stream
+	var keyBuf [32 + 16 + 16]byte
+	keys := keyBuf[:]
+	if n := a.c.BlockKeySize + 32; n <= len(keyBuf) {
+		keys = keyBuf[:n]
+	} else {
+		keys = make([]byte, n)
+	}
+	a.ks.XORKeyStream(keys, keys)
+	a.block.Block = hbshconfig.NewBlock("adiantum", a.c, keys[:a.c.BlockKeySize])
+	var keyT, keyM [32]byte
+	copy(keyT[:16], keys[a.c.BlockKeySize:])
+	copy(keyM[:16], keys[a.c.BlockKeySize+16:])
+	a.hash.keyT.Init(&keyT)
+	a.hash.keyM.Init(&keyM)
+	a.nhLen = 0
+}
+
+// Wipe erases the key material of a: its key, the key stream, the hash keys,
+// and the block cipher, if it implements hbsh.Wiper. Like hbsh.HBSH.Wipe, it
+// reports whether everything was wiped. a must not be used again until it is
+// rekeyed.
+func (a *Agile) Wipe() bool {
+	a.key = [32]byte{}
+	a.ks.Wipe()
+	a.hash.Wipe()
+	a.nhLen = 0
+	w, ok := a.block.Block.(hbsh.Wiper)
+	if ok {
+		w.Wipe()
+	}
+	a.block.Block = nil
+	return ok
+}
+
+// deriveNH ensures that enough of the NH key has been derived to hash a
+// message of n bytes.
+func (a *Agile) deriveNH(n int) {
+	if need := nh.KeyLen(n); need > a.nhLen {
+		k := a.hash.keyNH[a.nhLen:need]
+		for i := range k {
+			k[i] = 0
+		}
+		a.ks.XORKeyStream(k, k)
+		a.nhLen = need
+	}
+}
+
+// Encrypt encrypts block using the specified tweak; see hbsh.HBSH.Encrypt.
+func (a *Agile) Encrypt(block, tweak []byte) []byte {
+	a.deriveNH(len(block) - 16)
+	return a.h.Encrypt(block, tweak)
+}
+
+// Decrypt decrypts block using the specified tweak; see hbsh.HBSH.Decrypt.
+func (a *Agile) Decrypt(block, tweak []byte) []byte {
+	a.deriveNH(len(block) - 16)
+	return a.h.Decrypt(block, tweak)
+}
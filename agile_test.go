@@ -0,0 +1,116 @@
+package adiantum
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"lukechampine.com/adiantum/hpolyc"
+	"lukechampine.com/adiantum/internal/xchacha"
+)
+
+func TestAgile(t *testing.T) {
+	configs := []Config{
+		{},
+		{Rounds: 8},
+		{Rounds: 20, BlockKeySize: 16},
+	}
+	for _, c := range configs {
+		key := make([]byte, 32)
+		rand.Read(key)
+		a := NewAgile(key, c)
+		for i := 0; i < 3; i++ {
+			if i > 0 {
+				rand.Read(key)
+				a.Rekey(key)
+			}
+			h := NewWithConfig(key, c)
+			// grow the derived NH key gradually, then shrink and regrow it
+			for _, size := range []int{16, 17, 100, 16, 528, 2000, 1040, 33} {
+				plaintext := make([]byte, size)
+				rand.Read(plaintext)
+				tweak := make([]byte, 12)
+				rand.Read(tweak)
+				exp := h.Encrypt(append([]byte(nil), plaintext...), tweak)
+				ciphertext := a.Encrypt(append([]byte(nil), plaintext...), tweak)
+				if !bytes.Equal(ciphertext, exp) {
+					t.Fatalf("%+v: Agile.Encrypt(%v) does not match NewWithConfig after %v rekeys", c, size, i)
+				}
+				if !bytes.Equal(a.Decrypt(ciphertext, tweak), plaintext) {
+					t.Fatalf("%+v: Agile.Decrypt(%v) did not recover plaintext", c, size)
+				}
+			}
+		}
+	}
+
+	// a fresh key must not reuse NH key bytes derived from the previous one
+	key := make([]byte, 32)
+	a := NewAgile(key, Config{})
+	a.Encrypt(make([]byte, 4096), nil)
+	key[0] = 1
+	a.Rekey(key)
+	if !bytes.Equal(a.Encrypt(make([]byte, 4096), nil), New(key).Encrypt(make([]byte, 4096), nil)) {
+		t.Fatal("Agile reused the NH key of the previous key")
+	}
+}
+
+func TestAgileWipe(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	a := NewAgile(key, Config{ConstantTimeAES: true})
+	a.Encrypt(make([]byte, 4096), nil)
+	if !a.Wipe() {
+		t.Fatal("Wipe did not wipe the constant-time block cipher")
+	}
+	if a.key != [32]byte{} {
+		t.Error("key was not wiped")
+	}
+	if a.ks != (xchacha.Stream{}) {
+		t.Error("key stream was not wiped")
+	}
+	if a.hash != (hashNHPoly1305{}) {
+		t.Error("hash keys were not wiped")
+	}
+
+	// a wiped cipher can be rekeyed
+	a.Rekey(key)
+	if !bytes.Equal(a.Encrypt(make([]byte, 4096), nil), New(key).Encrypt(make([]byte, 4096), nil)) {
+		t.Fatal("Agile does not match New after Wipe and Rekey")
+	}
+}
+
+func BenchmarkSetup(b *testing.B) {
+	// per-file setup: a new key, followed by a single message
+	for _, size := range []int{64, 512, 4096} {
+		key := make([]byte, 32)
+		block := make([]byte, size)
+		tweak := make([]byte, 12)
+		b.Run(fmt.Sprintf("Adiantum_New_%v", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				key[0] = byte(i)
+				New(key).Encrypt(block, tweak)
+			}
+		})
+		b.Run(fmt.Sprintf("Adiantum_Rekey_%v", size), func(b *testing.B) {
+			a := NewAgile(key, Config{})
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				key[0] = byte(i)
+				a.Rekey(key)
+				a.Encrypt(block, tweak)
+			}
+		})
+		b.Run(fmt.Sprintf("HPolyC_New_%v", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				key[0] = byte(i)
+				hpolyc.New(key).Encrypt(block, tweak)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package adiantum
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"lukechampine.com/adiantum/hpolyc"
+	"lukechampine.com/adiantum/internal/xchacha"
+	"lukechampine.com/adiantum/nh"
+)
+
+var benchGHz = flag.Float64("bench.ghz", 0, "CPU frequency in GHz; if set, BenchmarkMatrix reports cycles per byte")
+
+// matrixSizes are the message sizes of BenchmarkMatrix, from a single AES
+// block to a large file chunk.
+var matrixSizes = []int{16, 64, 256, 512, 1024, 4096, 16384, 65536}
+
+// BenchmarkMatrix compares Adiantum and HPolyC with their most expensive
+// components, XChaCha and NH, across message sizes. Sub-benchmark names are of
+// the form Row/size=N, so the output can be compared with benchstat, and
+// cmd/benchtable can turn it into the README table:
+//
+//	go test -run XXX -bench Matrix -count 5 . | go run ./cmd/benchtable
+func BenchmarkMatrix(b *testing.B) {
+	key := make([]byte, 32)
+	tweak := make([]byte, 12)
+	run := func(name string, fn func(msg []byte)) {
+		for _, size := range matrixSizes {
+			b.Run(fmt.Sprintf("%v/size=%v", name, size), func(b *testing.B) {
+				msg := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				start := time.Now()
+				for i := 0; i < b.N; i++ {
+					fn(msg)
+				}
+				if *benchGHz > 0 {
+					ns := float64(time.Since(start).Nanoseconds()) / float64(b.N)
+					b.ReportMetric(ns**benchGHz/float64(size), "cycles/B")
+				}
+			})
+		}
+	}
+
+	for _, rounds := range []int{8, 12, 20} {
+		c := NewWithConfig(key, Config{Rounds: rounds})
+		run(fmt.Sprintf("Adiantum_XChaCha%v", rounds), func(msg []byte) { c.Encrypt(msg, tweak) })
+	}
+	for _, rounds := range []int{8, 12, 20} {
+		c := hpolyc.NewWithConfig(key, hpolyc.Config{Rounds: rounds})
+		run(fmt.Sprintf("HPolyC_XChaCha%v", rounds), func(msg []byte) { c.Encrypt(msg, tweak) })
+	}
+	for _, rounds := range []int{8, 12, 20} {
+		rounds := rounds
+		nonce := make([]byte, xchacha.NonceSize)
+		run(fmt.Sprintf("XChaCha%v", rounds), func(msg []byte) { xchacha.XORKeyStream(msg, msg, nonce, key, rounds) })
+	}
+	nhKey := make([]byte, nh.KeySize)
+	hashes := make([]byte, 0, (matrixSizes[len(matrixSizes)-1]/nh.ChunkSize)*nh.HashSize)
+	run("NH", func(msg []byte) { nh.Hash(hashes, msg, nhKey) })
+}
@@ -0,0 +1,220 @@
+// Command benchtable summarizes the output of BenchmarkMatrix as a Markdown
+// table, with one row per primitive and one column per message size. Each cell
+// is the median of the runs of that benchmark.
+//
+// Usage:
+//
+//	go test -run XXX -bench Matrix -count 5 . | benchtable -readme README.md
+//
+// With -readme, the table replaces the text between the benchtable markers in
+// the specified file; otherwise it is written to stdout.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	beginMarker = "<!-- benchtable -->"
+	endMarker   = "<!-- /benchtable -->"
+)
+
+// A result is the set of measurements of one benchmark.
+type result struct {
+	vals []float64
+}
+
+// A table holds the parsed benchmark output.
+type table struct {
+	cpu     string
+	rows    []string
+	sizes   []int
+	results map[string]map[int]*result
+}
+
+// parse reads benchmark output, collecting the specified metric from every
+// BenchmarkMatrix line.
+func parse(r io.Reader, metric string) (*table, error) {
+	t := &table{results: make(map[string]map[int]*result)}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "cpu: ") && t.cpu == "" {
+			t.cpu = strings.TrimPrefix(line, "cpu: ")
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "BenchmarkMatrix/") {
+			continue
+		}
+		// BenchmarkMatrix/Row/size=N-P
+		name := strings.TrimPrefix(fields[0], "BenchmarkMatrix/")
+		if i := strings.LastIndexByte(name, '-'); i >= 0 {
+			name = name[:i]
+		}
+		i := strings.LastIndex(name, "/size=")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed benchmark name %q", fields[0])
+		}
+		row := name[:i]
+		size, err := strconv.Atoi(name[i+len("/size="):])
+		if err != nil {
+			return nil, fmt.Errorf("malformed benchmark name %q", fields[0])
+		}
+		// fields after the iteration count are value-unit pairs
+		for j := 2; j+1 < len(fields); j += 2 {
+			if fields[j+1] != metric {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed value %q", fields[j])
+			}
+			t.add(row, size, v)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	} else if len(t.rows) == 0 {
+		return nil, fmt.Errorf("no BenchmarkMatrix results with metric %q", metric)
+	}
+	sort.Ints(t.sizes)
+	return t, nil
+}
+
+func (t *table) add(row string, size int, v float64) {
+	if t.results[row] == nil {
+		t.results[row] = make(map[int]*result)
+		t.rows = append(t.rows, row)
+	}
+	res, ok := t.results[row][size]
+	if !ok {
+		res = new(result)
+		t.results[row][size] = res
+		if !containsInt(t.sizes, size) {
+			t.sizes = append(t.sizes, size)
+		}
+	}
+	res.vals = append(res.vals, v)
+}
+
+func containsInt(s []int, n int) bool {
+	for _, m := range s {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+func median(vals []float64) float64 {
+	s := append([]float64(nil), vals...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+func formatSize(n int) string {
+	if n >= 1024 && n%1024 == 0 {
+		return fmt.Sprintf("%v KiB", n/1024)
+	}
+	return fmt.Sprintf("%v B", n)
+}
+
+func formatValue(v float64) string {
+	if v >= 100 {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// markdown renders t as a Markdown table.
+func (t *table) markdown(metric string) []byte {
+	var buf bytes.Buffer
+	if t.cpu != "" {
+		fmt.Fprintf(&buf, "%v, %v:\n\n", metric, t.cpu)
+	} else {
+		fmt.Fprintf(&buf, "%v:\n\n", metric)
+	}
+	buf.WriteString("| |")
+	for _, size := range t.sizes {
+		fmt.Fprintf(&buf, " %v |", formatSize(size))
+	}
+	buf.WriteString("\n|---|")
+	for range t.sizes {
+		buf.WriteString("---:|")
+	}
+	buf.WriteString("\n")
+	for _, row := range t.rows {
+		fmt.Fprintf(&buf, "| %v |", row)
+		for _, size := range t.sizes {
+			if res, ok := t.results[row][size]; ok {
+				fmt.Fprintf(&buf, " %v |", formatValue(median(res.vals)))
+			} else {
+				buf.WriteString(" |")
+			}
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// replaceMarked replaces the text between the benchtable markers in doc.
+func replaceMarked(doc, repl []byte) ([]byte, error) {
+	begin := bytes.Index(doc, []byte(beginMarker))
+	end := bytes.Index(doc, []byte(endMarker))
+	if begin < 0 || end < begin {
+		return nil, errors.New("benchtable markers not found")
+	}
+	begin += len(beginMarker)
+	var buf bytes.Buffer
+	buf.Write(doc[:begin])
+	buf.WriteString("\n")
+	buf.Write(repl)
+	buf.Write(doc[end:])
+	return buf.Bytes(), nil
+}
+
+func main() {
+	metric := flag.String("metric", "MB/s", "metric to tabulate (MB/s, ns/op, or cycles/B)")
+	readme := flag.String("readme", "", "file whose benchtable section should be replaced (default: write to stdout)")
+	flag.Parse()
+
+	if err := run(os.Stdin, *metric, *readme); err != nil {
+		fmt.Fprintln(os.Stderr, "benchtable:", err)
+		os.Exit(1)
+	}
+}
+
+func run(r io.Reader, metric, readme string) error {
+	t, err := parse(r, metric)
+	if err != nil {
+		return err
+	}
+	md := t.markdown(metric)
+	if readme == "" {
+		_, err := os.Stdout.Write(md)
+		return err
+	}
+	doc, err := ioutil.ReadFile(readme)
+	if err != nil {
+		return err
+	}
+	doc, err = replaceMarked(doc, md)
+	if err != nil {
+		return fmt.Errorf("%v: %w", readme, err)
+	}
+	return ioutil.WriteFile(readme, doc, 0666)
+}
@@ -0,0 +1,82 @@
+// Command genvectors generates Adiantum and HPolyC test vectors from the
+// reference implementation, in the JSON schema used by the testdata files.
+//
+// Usage:
+//
+//	genvectors -cipher Adiantum -rounds 12 -aes 256 -lengths 16,32,4096 -tweaks 0,17,32 > vectors.json
+//
+// The same seed always produces the same vectors.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+
+	"lukechampine.com/adiantum/internal/kat"
+)
+
+func parseInts(s string) ([]int, error) {
+	var ns []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+func main() {
+	cipher := flag.String("cipher", "Adiantum", "cipher to generate vectors for (Adiantum or HPolyC)")
+	rounds := flag.Int("rounds", 12, "number of XChaCha rounds (8, 12, or 20)")
+	aesBits := flag.Int("aes", 256, "AES key size in bits (128, 192, or 256)")
+	lengths := flag.String("lengths", "16,17,32,64,128,512,4096", "comma-separated plaintext lengths")
+	tweaks := flag.String("tweaks", "0,17,32", "comma-separated tweak lengths")
+	seed := flag.Int64("seed", 0, "seed for generating keys, tweaks, and plaintexts")
+	out := flag.String("o", "", "output file (default stdout)")
+	flag.Parse()
+
+	if err := run(*cipher, *rounds, *aesBits, *lengths, *tweaks, *seed, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "genvectors:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cipher string, rounds, aesBits int, lengthsStr, tweaksStr string, seed int64, out string) error {
+	if rounds != 8 && rounds != 12 && rounds != 20 {
+		return fmt.Errorf("invalid number of rounds %v", rounds)
+	}
+	if aesBits != 128 && aesBits != 192 && aesBits != 256 {
+		return fmt.Errorf("invalid AES key size %v", aesBits)
+	}
+	lengths, err := parseInts(lengthsStr)
+	if err != nil {
+		return fmt.Errorf("invalid lengths: %w", err)
+	}
+	tweaks, err := parseInts(tweaksStr)
+	if err != nil {
+		return fmt.Errorf("invalid tweak lengths: %w", err)
+	}
+	vs, err := kat.Generate(kat.Spec(cipher, rounds, aesBits/8), rand.New(rand.NewSource(seed)), lengths, tweaks)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		return kat.Write(os.Stdout, vs)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := kat.Write(f, vs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
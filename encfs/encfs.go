@@ -0,0 +1,494 @@
+// Package encfs provides access to a directory tree whose file names and
+// contents are encrypted with Adiantum.
+//
+// Each file name is padded with zeros to a multiple of 16 bytes, encrypted
+// using the SHA-256 hash of its parent directory's (plaintext) path as the
+// tweak, and encoded with unpadded URL-safe base64. Since Adiantum is
+// length-preserving, the encrypted name reveals only the padded length of the
+// original.
+//
+// Each file begins with a header containing a random 16-byte nonce and the
+// plaintext size. The contents follow, encrypted in chunks of ChunkSize bytes;
+// each chunk is encrypted using its index, as an 8-byte little-endian integer,
+// followed by the nonce as the tweak. A final chunk shorter than 16 bytes is
+// padded with zeros to 16 bytes. Chunks can be decrypted independently, so
+// files support random access.
+//
+// Like all disk encryption, this scheme provides confidentiality but not
+// integrity: an attacker with write access can replace a chunk with an older
+// version of the same chunk, or corrupt it undetectably.
+package encfs // import "lukechampine.com/adiantum/encfs"
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"lukechampine.com/adiantum"
+	"lukechampine.com/adiantum/hbsh"
+)
+
+// ChunkSize is the size of each encrypted chunk of file data.
+const ChunkSize = 4096
+
+// headerSize is the size of the file header: a 16-byte nonce followed by the
+// 8-byte little-endian plaintext size.
+const headerSize = 16 + 8
+
+// maxNameLen is the maximum length of a plaintext name, chosen so that the
+// encoded name fits within the 255-byte limit of most filesystems.
+const maxNameLen = 176
+
+// ErrNameTooLong is returned when a file name exceeds the maximum length.
+var ErrNameTooLong = errors.New("encfs: name too long")
+
+// An FS is an encrypted directory tree. It implements fs.FS, fs.ReadDirFS,
+// fs.ReadFileFS, and fs.StatFS. It is safe for concurrent use.
+type FS struct {
+	root    string
+	ciphers sync.Pool // of *hbsh.HBSH, each a clone of the same cipher
+}
+
+// New returns an FS rooted at the specified directory, encrypted with the
+// specified 32-byte key.
+func New(root string, key []byte) *FS {
+	c := adiantum.New(key)
+	fsys := &FS{root: root}
+	fsys.ciphers.New = func() interface{} { return c.Clone() }
+	return fsys
+}
+
+// encrypt encrypts buf with one of the FS's ciphers.
+func (fsys *FS) encrypt(buf, tweak []byte) {
+	c := fsys.ciphers.Get().(*hbsh.HBSH)
+	c.Encrypt(buf, tweak)
+	fsys.ciphers.Put(c)
+}
+
+// decrypt decrypts buf with one of the FS's ciphers.
+func (fsys *FS) decrypt(buf, tweak []byte) {
+	c := fsys.ciphers.Get().(*hbsh.HBSH)
+	c.Decrypt(buf, tweak)
+	fsys.ciphers.Put(c)
+}
+
+// chunkPool holds buffers for reading chunks.
+var chunkPool = sync.Pool{
+	New: func() interface{} { return new([ChunkSize]byte) },
+}
+
+func dirTweak(dir string) []byte {
+	sum := sha256.Sum256([]byte(dir))
+	return sum[:]
+}
+
+func chunkTweak(buf *[24]byte, index uint64, nonce *[16]byte) []byte {
+	binary.LittleEndian.PutUint64(buf[:8], index)
+	copy(buf[8:], nonce[:])
+	return buf[:]
+}
+
+func (fsys *FS) encryptName(dir, name string) (string, error) {
+	if len(name) > maxNameLen {
+		return "", ErrNameTooLong
+	}
+	n := (len(name) + 15) &^ 15
+	if n == 0 {
+		n = 16
+	}
+	buf := make([]byte, n)
+	copy(buf, name)
+	fsys.encrypt(buf, dirTweak(dir))
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func (fsys *FS) decryptName(dir, encName string) (string, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(encName)
+	if err != nil || len(buf) < 16 || len(buf)%16 != 0 {
+		return "", errors.New("encfs: invalid encrypted name")
+	}
+	fsys.decrypt(buf, dirTweak(dir))
+	name := strings.TrimRight(string(buf), "\x00")
+	if !fs.ValidPath(name) || strings.ContainsAny(name, "/\x00") || name == "." {
+		return "", errors.New("encfs: invalid encrypted name")
+	}
+	return name, nil
+}
+
+// realPath returns the on-disk path corresponding to the plaintext path name.
+func (fsys *FS) realPath(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	real := fsys.root
+	if name == "." {
+		return real, nil
+	}
+	dir := "."
+	for _, elem := range strings.Split(name, "/") {
+		enc, err := fsys.encryptName(dir, elem)
+		if err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		real = filepath.Join(real, enc)
+		dir = path.Join(dir, elem)
+	}
+	return real, nil
+}
+
+func (fsys *FS) encryptChunk(buf []byte, index uint64, nonce *[16]byte) {
+	var tweak [24]byte
+	fsys.encrypt(buf, chunkTweak(&tweak, index, nonce))
+}
+
+func (fsys *FS) decryptChunk(buf []byte, index uint64, nonce *[16]byte) {
+	var tweak [24]byte
+	fsys.decrypt(buf, chunkTweak(&tweak, index, nonce))
+}
+
+// Open implements fs.FS. The returned file implements io.ReaderAt and
+// io.Seeker if it is a regular file, and fs.ReadDirFile if it is a directory.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	real, err := fsys.realPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(real)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+	}
+	if info.IsDir() {
+		return &dir{fsys: fsys, path: name, f: f, info: info}, nil
+	}
+	fl := &file{fsys: fsys, path: name, f: f, info: info}
+	if err := fl.readHeader(); err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return fl, nil
+}
+
+// ReadDir implements fs.ReadDirFS. Entries whose names cannot be decrypted are
+// skipped.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	real, err := fsys.realPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	osEntries, err := os.ReadDir(real)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: unwrapPathError(err)}
+	}
+	entries := make([]fs.DirEntry, 0, len(osEntries))
+	for _, e := range osEntries {
+		plain, err := fsys.decryptName(name, e.Name())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, &dirEntry{fsys: fsys, path: path.Join(name, plain), name: plain, entry: e})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// Stat implements fs.StatFS.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fl, ok := f.(*file)
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	buf := make([]byte, fl.size)
+	if _, err := fl.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// WriteFile encrypts data and writes it to the named file, creating it with
+// the specified permissions if necessary. The parent directory must exist.
+func (fsys *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	real, err := fsys.realPath("write", name)
+	if err != nil {
+		return err
+	}
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	n := headerSize + len(data)
+	if rem := len(data) % ChunkSize; rem > 0 && rem < 16 {
+		n += 16 - rem
+	}
+	buf := make([]byte, n)
+	copy(buf, nonce[:])
+	binary.LittleEndian.PutUint64(buf[16:], uint64(len(data)))
+	body := buf[headerSize:]
+	copy(body, data)
+	for i := uint64(0); len(body) > 0; i++ {
+		chunk := body
+		if len(chunk) > ChunkSize {
+			chunk = chunk[:ChunkSize]
+		}
+		fsys.encryptChunk(chunk, i, &nonce)
+		body = body[len(chunk):]
+	}
+	if err := os.WriteFile(real, buf, perm); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: unwrapPathError(err)}
+	}
+	return nil
+}
+
+// MkdirAll creates the named directory, along with any necessary parents.
+func (fsys *FS) MkdirAll(name string, perm fs.FileMode) error {
+	real, err := fsys.realPath("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(real, perm); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: unwrapPathError(err)}
+	}
+	return nil
+}
+
+// Remove removes the named file or (empty) directory.
+func (fsys *FS) Remove(name string) error {
+	real, err := fsys.realPath("remove", name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(real); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: unwrapPathError(err)}
+	}
+	return nil
+}
+
+// unwrapPathError strips the on-disk path from err, so that encrypted names do
+// not leak into errors that report plaintext paths.
+func unwrapPathError(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
+
+// fileInfo overrides the name and size of an underlying fs.FileInfo.
+type fileInfo struct {
+	fs.FileInfo
+	name string
+	size int64
+}
+
+func (fi *fileInfo) Name() string { return fi.name }
+func (fi *fileInfo) Size() int64  { return fi.size }
+
+// A file is an open encrypted file.
+type file struct {
+	fsys  *FS
+	path  string
+	f     *os.File
+	info  fs.FileInfo
+	nonce [16]byte
+	size  int64
+
+	mu  sync.Mutex // guards off
+	off int64
+}
+
+func (fl *file) readHeader() error {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(fl.f, hdr[:]); err != nil {
+		return errors.New("encfs: invalid file header")
+	}
+	copy(fl.nonce[:], hdr[:16])
+	fl.size = int64(binary.LittleEndian.Uint64(hdr[16:]))
+	if fl.size < 0 || fl.size > fl.info.Size()-headerSize {
+		return errors.New("encfs: invalid file header")
+	}
+	return nil
+}
+
+// Stat implements fs.File.
+func (fl *file) Stat() (fs.FileInfo, error) {
+	return &fileInfo{FileInfo: fl.info, name: path.Base(fl.path), size: fl.size}, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (fl *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: fl.path, Err: fs.ErrInvalid}
+	}
+	var n int
+	chunk := chunkPool.Get().(*[ChunkSize]byte)
+	defer chunkPool.Put(chunk)
+	for len(p) > 0 && off < fl.size {
+		index := off / ChunkSize
+		chunkOff := index * ChunkSize
+		plainLen := fl.size - chunkOff
+		if plainLen > ChunkSize {
+			plainLen = ChunkSize
+		}
+		cipherLen := plainLen
+		if cipherLen < 16 {
+			cipherLen = 16
+		}
+		buf := chunk[:cipherLen]
+		if _, err := fl.f.ReadAt(buf, headerSize+chunkOff); err != nil {
+			return n, &fs.PathError{Op: "read", Path: fl.path, Err: unwrapPathError(err)}
+		}
+		fl.fsys.decryptChunk(buf, uint64(index), &fl.nonce)
+		c := copy(p, buf[off-chunkOff:plainLen])
+		n += c
+		p = p[c:]
+		off += int64(c)
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements fs.File.
+func (fl *file) Read(p []byte) (int, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	n, err := fl.ReadAt(p, fl.off)
+	fl.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (fl *file) Seek(offset int64, whence int) (int64, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += fl.off
+	case io.SeekEnd:
+		offset += fl.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: fl.path, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: fl.path, Err: fs.ErrInvalid}
+	}
+	fl.off = offset
+	return offset, nil
+}
+
+// Close implements fs.File.
+func (fl *file) Close() error {
+	return fl.f.Close()
+}
+
+// A dir is an open encrypted directory.
+type dir struct {
+	fsys    *FS
+	path    string
+	f       *os.File
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+// Stat implements fs.File.
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return &fileInfo{FileInfo: d.info, name: path.Base(d.path), size: d.info.Size()}, nil
+}
+
+// Read implements fs.File.
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errors.New("is a directory")}
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.path)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	} else if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// Close implements fs.File.
+func (d *dir) Close() error {
+	return d.f.Close()
+}
+
+// A dirEntry is an entry in an encrypted directory.
+type dirEntry struct {
+	fsys  *FS
+	path  string
+	name  string
+	entry fs.DirEntry
+}
+
+func (e *dirEntry) Name() string      { return e.name }
+func (e *dirEntry) IsDir() bool       { return e.entry.IsDir() }
+func (e *dirEntry) Type() fs.FileMode { return e.entry.Type() }
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	return e.fsys.Stat(e.path)
+}
+
+// ensure interfaces are satisfied
+var (
+	_ fs.ReadDirFS   = (*FS)(nil)
+	_ fs.ReadFileFS  = (*FS)(nil)
+	_ fs.StatFS      = (*FS)(nil)
+	_ fs.ReadDirFile = (*dir)(nil)
+	_ io.ReaderAt    = (*file)(nil)
+	_ io.Seeker      = (*file)(nil)
+)
@@ -0,0 +1,173 @@
+package encfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func randKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+func TestFS(t *testing.T) {
+	root := t.TempDir()
+	fsys := New(root, randKey())
+	files := map[string][]byte{
+		"empty":              nil,
+		"tiny":               []byte("hi"),
+		"config.json":        []byte(`{"secret": true}`),
+		"a/chunk":            make([]byte, ChunkSize),
+		"a/b/big":            make([]byte, 3*ChunkSize+5),
+		"a/b/c/almost-chunk": make([]byte, 2*ChunkSize-1),
+	}
+	for name, data := range files {
+		rand.Read(data)
+		if err := fsys.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			t.Fatal(err)
+		} else if err := fsys.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	if err := fstest.TestFS(fsys, names...); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range files {
+		got, err := fsys.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, data) {
+			t.Fatalf("%v: contents do not match", name)
+		}
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if info.Size() != int64(len(data)) {
+			t.Fatalf("%v: wrong size: expected %v, got %v", name, len(data), info.Size())
+		}
+	}
+
+	// no plaintext names should appear on disk
+	plainNames := make(map[string]bool)
+	for name := range files {
+		for _, elem := range strings.Split(name, "/") {
+			plainNames[elem] = true
+		}
+	}
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if path != root && plainNames[info.Name()] {
+			t.Errorf("plaintext name %q appears on disk", info.Name())
+		}
+		return nil
+	})
+}
+
+func TestReadAt(t *testing.T) {
+	fsys := New(t.TempDir(), randKey())
+	data := make([]byte, 5*ChunkSize+3)
+	rand.Read(data)
+	if err := fsys.WriteFile("f", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ra := f.(io.ReaderAt)
+	for _, r := range []struct{ off, n int }{
+		{0, 1}, {ChunkSize - 1, 2}, {ChunkSize, ChunkSize}, {100, 3 * ChunkSize}, {len(data) - 3, 3},
+	} {
+		buf := make([]byte, r.n)
+		if _, err := ra.ReadAt(buf, int64(r.off)); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, data[r.off:][:r.n]) {
+			t.Fatalf("ReadAt(%v, %v) returned wrong data", r.off, r.n)
+		}
+	}
+	buf := make([]byte, 10)
+	if n, err := ra.ReadAt(buf, int64(len(data)-4)); err != io.EOF || n != 4 {
+		t.Fatalf("expected (4, EOF), got (%v, %v)", n, err)
+	} else if !bytes.Equal(buf[:n], data[len(data)-4:]) {
+		t.Fatal("ReadAt past end returned wrong data")
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	fsys := New(t.TempDir(), randKey())
+	data := make([]byte, 3*ChunkSize+7)
+	rand.Read(data)
+	if err := fsys.WriteFile("shared", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ra := f.(io.ReaderAt)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "f" + strings.Repeat("x", i)
+			if err := fsys.WriteFile(name, data[i:], 0600); err != nil {
+				t.Error(err)
+			} else if got, err := fsys.ReadFile(name); err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(got, data[i:]) {
+				t.Errorf("%v: read back wrong data", name)
+			}
+			buf := make([]byte, ChunkSize)
+			if _, err := ra.ReadAt(buf, int64(i*100)); err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(buf, data[i*100:][:ChunkSize]) {
+				t.Errorf("ReadAt(%v) returned wrong data", i*100)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWrongKey(t *testing.T) {
+	root := t.TempDir()
+	if err := New(root, randKey()).WriteFile("secret", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fsys := New(root, randKey())
+	if _, err := fsys.Open("secret"); !os.IsNotExist(err) {
+		t.Fatal("expected not-exist error, got", err)
+	}
+	if entries, err := fsys.ReadDir("."); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Fatal("expected names encrypted with another key to be skipped")
+	}
+}
+
+func TestNameTooLong(t *testing.T) {
+	fsys := New(t.TempDir(), randKey())
+	err := fsys.WriteFile(strings.Repeat("a", maxNameLen+1), nil, 0600)
+	if pe, ok := err.(*fs.PathError); !ok || pe.Err != ErrNameTooLong {
+		t.Fatal("expected ErrNameTooLong, got", err)
+	}
+	if err := fsys.WriteFile(strings.Repeat("a", maxNameLen), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
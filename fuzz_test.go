@@ -0,0 +1,116 @@
+package adiantum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"testing"
+
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/internal/xchacha"
+	"lukechampine.com/adiantum/nh"
+	"lukechampine.com/adiantum/reference"
+)
+
+// fuzzKey returns a 32-byte key derived from b.
+func fuzzKey(b []byte) []byte {
+	key := make([]byte, 32)
+	copy(key, b)
+	return key
+}
+
+func FuzzXChaCha(f *testing.F) {
+	f.Add([]byte("key"), []byte("nonce"), []byte("message"), uint8(12))
+	f.Add([]byte{}, []byte{}, make([]byte, 1000), uint8(20))
+	f.Fuzz(func(t *testing.T, key, nonceSeed, msg []byte, rounds uint8) {
+		r := []int{8, 12, 20}[rounds%3]
+		nonce := make([]byte, xchacha.NonceSize)
+		copy(nonce, nonceSeed)
+		exp := reference.XChaCha(r, fuzzKey(key), nonce, len(msg))
+		for i := range exp {
+			exp[i] ^= msg[i]
+		}
+		got := make([]byte, len(msg))
+		xchacha.XORKeyStream(got, msg, nonce, fuzzKey(key), r)
+		if !bytes.Equal(got, exp) {
+			t.Fatalf("XORKeyStream mismatch:\nexp: %x\ngot: %x", exp, got)
+		}
+	})
+}
+
+func FuzzNH(f *testing.F) {
+	f.Add([]byte("key"), []byte("message"))
+	f.Add(bytes.Repeat([]byte{0xFF}, 1072), bytes.Repeat([]byte{0xFF}, 1024))
+	f.Fuzz(func(t *testing.T, keySeed, msg []byte) {
+		if len(msg) > 1024 {
+			msg = msg[:1024]
+		}
+		msg = msg[:len(msg)&^15]
+		key := make([]byte, len(msg)+48)
+		copy(key, keySeed)
+		exp := reference.NH(key, msg)
+		var got [32]byte
+		nh.Sum(&got, msg, key)
+		if !bytes.Equal(got[:], exp) {
+			t.Fatalf("NH mismatch:\nexp: %x\ngot: %x", exp, got)
+		}
+	})
+}
+
+func FuzzHashNHPoly1305(f *testing.F) {
+	f.Add([]byte("key"), []byte("message"), []byte("tweak"))
+	f.Add(bytes.Repeat([]byte{0xFF}, 32), bytes.Repeat([]byte{0xFF}, 3000), bytes.Repeat([]byte{0xFF}, 32))
+	f.Fuzz(func(t *testing.T, key, msg, tweak []byte) {
+		if len(tweak) > MaxTweakSize {
+			tweak = tweak[:MaxTweakSize]
+		}
+		_, _, hash := makeAdiantum(fuzzKey(key), Config{})
+		exp := reference.Adiantum(fuzzKey(key), 12).Hash(tweak, msg)
+		if got := hash.Sum(nil, msg, tweak); !bytes.Equal(got, exp) {
+			t.Fatalf("hash mismatch:\nexp: %x\ngot: %x", exp, got)
+		}
+	})
+}
+
+func FuzzAdiantum(f *testing.F) {
+	f.Add([]byte("key"), []byte("tweak"), make([]byte, 16), uint8(0))
+	f.Add([]byte("key"), make([]byte, 32), make([]byte, 4096), uint8(1))
+	f.Add([]byte{}, []byte{}, make([]byte, 1100), uint8(2))
+	f.Fuzz(func(t *testing.T, keySeed, tweak, plaintext []byte, variant uint8) {
+		if len(tweak) > MaxTweakSize {
+			tweak = tweak[:MaxTweakSize]
+		}
+		if len(plaintext) < 16 {
+			plaintext = append(plaintext, make([]byte, 16-len(plaintext))...)
+		}
+		key := fuzzKey(keySeed)
+		rounds := []int{8, 12, 20}[variant%3]
+		c := []func([]byte) *hbsh.HBSH{New8, New, New20}[variant%3](key)
+		ref := reference.Adiantum(key, rounds)
+
+		exp := ref.Encrypt(plaintext, tweak)
+		ciphertext := c.Encrypt(append([]byte(nil), plaintext...), tweak)
+		if !bytes.Equal(ciphertext, exp) {
+			t.Fatalf("Encrypt mismatch:\nexp: %x\ngot: %x", exp, ciphertext)
+		}
+		if got := ref.Decrypt(ciphertext, tweak); !bytes.Equal(got, plaintext) {
+			t.Fatal("reference decryption did not recover plaintext")
+		}
+		if got := c.Decrypt(append([]byte(nil), ciphertext...), tweak); !bytes.Equal(got, plaintext) {
+			t.Fatal("Decrypt did not recover plaintext")
+		}
+
+		// flipping any single bit should scramble the entire ciphertext
+		bit := int(binary.LittleEndian.Uint16(append(keySeed, 0, 0))) % (8 * len(plaintext))
+		flipped := append([]byte(nil), plaintext...)
+		flipped[bit/8] ^= 1 << (bit % 8)
+		flippedCiphertext := c.Encrypt(flipped, tweak)
+		var diff int
+		for i := range ciphertext {
+			diff += bits.OnesCount8(ciphertext[i] ^ flippedCiphertext[i])
+		}
+		if diff < 8*len(ciphertext)/4 {
+			t.Fatalf("flipping bit %v changed only %v of %v ciphertext bits", bit, diff, 8*len(ciphertext))
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package hbsh
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"hash"
+
+	"lukechampine.com/adiantum/internal/poly1305"
+)
+
+// StreamFunc adapts a function returning a cipher.Stream for a nonce, such as
+// one wrapping cipher.NewCTR, into a StreamCipher. HBSH passes the 16-byte
+// intermediate block as the nonce.
+type StreamFunc func(nonce []byte) cipher.Stream
+
+// XORKeyStream implements StreamCipher.
+func (fn StreamFunc) XORKeyStream(msg, nonce []byte) {
+	fn(nonce).XORKeyStream(msg, msg)
+}
+
+// maxPolyTweakSize is the largest tweak whose length in bits fits in the 32-bit
+// length prefix used by PolyHash and Poly1305Hash.
+const maxPolyTweakSize = 1<<29 - 1
+
+// polyHash computes H(T, M) = P(len(T) || T || pad || M), where len(T) is the
+// bit length of the tweak as a 32-bit little-endian integer and pad is
+// 16 - (4+len(T)) mod 16 zero bytes, which align M to a 16-byte boundary.
+type polyHash struct {
+	newHash func() hash.Hash
+}
+
+// Sum implements TweakableHash.
+func (p polyHash) Sum(dst, msg, tweak []byte) []byte {
+	lenBuf := tweakLen(tweak)
+	h := p.newHash()
+	h.Write(lenBuf[:])
+	h.Write(tweak)
+	h.Write(tweakPad(tweak))
+	h.Write(msg)
+	return h.Sum(dst)
+}
+
+// MaxTweakSize implements TweakLimiter.
+func (p polyHash) MaxTweakSize() int { return maxPolyTweakSize }
+
+// PolyHash returns a TweakableHash built from a keyed polynomial hash with a
+// 16-byte output, such as GHASH or Poly1305. newHash must return a new,
+// identically keyed hash on each call. The hash input is the bit length of the
+// tweak as a 32-bit little-endian integer, the tweak, zero padding to a 16-byte
+// boundary, and finally the message. As in HPolyC, the padding is a whole block
+// of zeros if the length and tweak are already aligned.
+func PolyHash(newHash func() hash.Hash) TweakableHash {
+	if newHash().Size() != 16 {
+		panic("hbsh: hash must have a 16-byte output")
+	}
+	return polyHash{newHash}
+}
+
+// tweakLen returns the length prefix of tweak in the hash input.
+func tweakLen(tweak []byte) (buf [4]byte) {
+	binary.LittleEndian.PutUint32(buf[:], uint32(8*len(tweak)))
+	return
+}
+
+var zeroPad [16]byte
+
+// tweakPad returns the zeros that follow tweak in the hash input. It is never
+// empty, matching HPolyC.
+func tweakPad(tweak []byte) []byte {
+	return zeroPad[(4+len(tweak))%16:]
+}
+
+type poly1305Hash struct {
+	key poly1305.Key
+}
+
+// writeTweak initializes mac and writes the part of the hash input that
+// precedes the message.
+func (p *poly1305Hash) writeTweak(mac *poly1305.MAC, tweak []byte) {
+	lenBuf := tweakLen(tweak)
+	mac.Init(&p.key)
+	mac.Write(lenBuf[:])
+	mac.Write(tweak)
+	mac.Write(tweakPad(tweak))
+}
+
+// Sum implements TweakableHash.
+func (p *poly1305Hash) Sum(dst, msg, tweak []byte) []byte {
+	var mac poly1305.MAC
+	p.writeTweak(&mac, tweak)
+	mac.Write(msg)
+	return mac.Sum(dst)
+}
+
+// WithTweak implements TweakPrecomputer. The tweak precedes the message in the
+// hash input, so the returned hash stores the Poly1305 state after the tweak
+// and resumes from it on every call.
+func (p *poly1305Hash) WithTweak(tweak []byte) FixedTweakHash {
+	t := new(poly1305Tweaked)
+	p.writeTweak(&t.mac, tweak)
+	return t
+}
+
+type poly1305Tweaked struct {
+	mac poly1305.MAC
+}
+
+func (t *poly1305Tweaked) Sum(dst, msg []byte) []byte {
+	mac := t.mac
+	mac.Write(msg)
+	return mac.Sum(dst)
+}
+
+// Wipe implements Wiper.
+func (p *poly1305Hash) Wipe() { *p = poly1305Hash{} }
+
+// MaxTweakSize implements TweakLimiter.
+func (p *poly1305Hash) MaxTweakSize() int { return maxPolyTweakSize }
+
+// NewPoly1305Hash returns a TweakableHash that hashes its input, encoded as in
+// PolyHash, with Poly1305. The key is the 16-byte Poly1305 multiplier r, and
+// the final addend s is zero. This is exactly the hash of HPolyC, including
+// its padding of the tweak.
+func NewPoly1305Hash(key []byte) TweakableHash {
+	if len(key) != 16 {
+		panic("hbsh: Poly1305 key must be 16 bytes long")
+	}
+	p := new(poly1305Hash)
+	var k [32]byte
+	copy(k[:], key)
+	p.key.Init(&k)
+	return p
+}
+
+// Build returns an HBSH cipher assembled from standard primitives: newStream
+// returns a keystream for a 16-byte nonce, block must have a 16-byte block
+// size, and newHash returns a keyed polynomial hash, which is made tweakable
+// with PolyHash. For example, an HCTR-like cipher can be built from AES-CTR and
+// GHASH. To use another TweakableHash, such as NewPoly1305Hash, pass
+// StreamFunc(newStream) to New instead.
+func Build(newStream func(nonce []byte) cipher.Stream, block cipher.Block, newHash func() hash.Hash) *HBSH {
+	if block.BlockSize() != 16 {
+		panic("hbsh: block cipher must have a 16-byte block size")
+	}
+	return New(StreamFunc(newStream), block, PolyHash(newHash))
+}
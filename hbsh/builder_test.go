@@ -0,0 +1,119 @@
+package hbsh_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/sha256"
+	"hash"
+	"testing"
+
+	"github.com/aead/chacha20/chacha"
+	"golang.org/x/crypto/poly1305"
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/hpolyc"
+)
+
+// polyMAC adapts an x/crypto Poly1305 MAC to hash.Hash.
+type polyMAC struct {
+	*poly1305.MAC
+	key [32]byte
+}
+
+func (p *polyMAC) Reset()         { p.MAC = poly1305.New(&p.key) }
+func (p *polyMAC) BlockSize() int { return 16 }
+
+func newPolyMAC(r []byte) func() hash.Hash {
+	return func() hash.Hash {
+		p := new(polyMAC)
+		copy(p.key[:16], r)
+		p.Reset()
+		return p
+	}
+}
+
+func TestBuildHPolyC(t *testing.T) {
+	// reassemble HPolyC from its primitives; chacha.NewCipher only matches
+	// Adiantum's XChaCha with 20 rounds, since it always uses HChaCha20
+	key := make([]byte, 32)
+	rand.Read(key)
+	keyBuf := make([]byte, 48)
+	xchacha := func(nonce []byte) cipher.Stream {
+		var nonceBuf [24]byte
+		nonceBuf[copy(nonceBuf[:], nonce)] = 1
+		s, err := chacha.NewCipher(nonceBuf[:], key, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	xchacha(nil).XORKeyStream(keyBuf, keyBuf)
+	block, _ := aes.NewCipher(keyBuf[:32])
+
+	exp := hpolyc.New20(key)
+	built := hbsh.Build(xchacha, block, newPolyMAC(keyBuf[32:]))
+	direct := hbsh.New(hbsh.StreamFunc(xchacha), block, hbsh.NewPoly1305Hash(keyBuf[32:]))
+	for _, tweakLen := range []int{0, 1, 12, 17, 28, 32, 100} {
+		for _, msgLen := range []int{16, 17, 100, 4096} {
+			tweak := make([]byte, tweakLen)
+			plaintext := make([]byte, msgLen)
+			rand.Read(tweak)
+			rand.Read(plaintext)
+			ciphertext := exp.Encrypt(append([]byte(nil), plaintext...), tweak)
+			for _, c := range []*hbsh.HBSH{built, direct} {
+				if got := c.Encrypt(append([]byte(nil), plaintext...), tweak); !bytes.Equal(got, ciphertext) {
+					t.Fatalf("Encrypt mismatch for %v-byte tweak, %v-byte message", tweakLen, msgLen)
+				}
+				if got := c.Decrypt(append([]byte(nil), ciphertext...), tweak); !bytes.Equal(got, plaintext) {
+					t.Fatalf("Decrypt mismatch for %v-byte tweak, %v-byte message", tweakLen, msgLen)
+				}
+			}
+		}
+	}
+	if built.MaxTweakSize() != hpolyc.MaxTweakSize {
+		t.Fatalf("expected MaxTweakSize of %v, got %v", hpolyc.MaxTweakSize, built.MaxTweakSize())
+	}
+}
+
+func TestBuildAESCTR(t *testing.T) {
+	keys := make([]byte, 32+32+16)
+	rand.Read(keys)
+	streamBlock, _ := aes.NewCipher(keys[:32])
+	block, _ := aes.NewCipher(keys[32:64])
+	c := hbsh.Build(func(nonce []byte) cipher.Stream {
+		return cipher.NewCTR(streamBlock, nonce)
+	}, block, newPolyMAC(keys[64:]))
+
+	plaintext := make([]byte, 512)
+	rand.Read(plaintext)
+	tweak := []byte("sector 7")
+	ciphertext := c.Encrypt(append([]byte(nil), plaintext...), tweak)
+	if bytes.Equal(ciphertext, plaintext) {
+		t.Fatal("Encrypt did not change plaintext")
+	} else if got := c.Decrypt(ciphertext, tweak); !bytes.Equal(got, plaintext) {
+		t.Fatal("Decrypt did not recover plaintext")
+	}
+}
+
+func TestBuildInvalid(t *testing.T) {
+	aesBlock, _ := aes.NewCipher(make([]byte, 16))
+	desBlock, _ := des.NewCipher(make([]byte, 8))
+	stream := func(nonce []byte) cipher.Stream { return cipher.NewCTR(aesBlock, nonce) }
+	for name, fn := range map[string]func(){
+		"block size":  func() { hbsh.Build(stream, desBlock, newPolyMAC(make([]byte, 16))) },
+		"hash size":   func() { hbsh.Build(stream, aesBlock, sha256.New) },
+		"poly key":    func() { hbsh.NewPoly1305Hash(make([]byte, 32)) },
+		"poly output": func() { hbsh.PolyHash(sha256.New) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: expected panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
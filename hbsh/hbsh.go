@@ -3,20 +3,51 @@ package hbsh // import "lukechampine.com/adiantum/hbsh"
 import (
 	"crypto/cipher"
 	"encoding/binary"
+	"errors"
 	"math/bits"
 )
 
+var (
+	// ErrBlockTooShort is returned by the checked API when a block is shorter
+	// than 16 bytes.
+	ErrBlockTooShort = errors.New("hbsh: block must be at least 16 bytes")
+
+	// ErrTweakTooLong is returned by the checked API when a tweak exceeds the
+	// cipher's MaxTweakSize.
+	ErrTweakTooLong = errors.New("hbsh: tweak exceeds maximum size")
+)
+
 // A StreamCipher xors msg with a keystream, modified by a nonce.
 type StreamCipher interface {
 	XORKeyStream(msg, nonce []byte)
 }
 
+// A MultiStreamCipher is a StreamCipher that can process several messages at
+// once. XORKeyStreams must be equivalent to calling XORKeyStream on each
+// message with the corresponding nonce.
+type MultiStreamCipher interface {
+	StreamCipher
+	XORKeyStreams(msgs, nonces [][]byte)
+}
+
 // TweakableHash is a tweakable cryptographic hash function. It appends the hash
 // of src to dst and returns it.
 type TweakableHash interface {
 	Sum(dst, src, tweak []byte) []byte
 }
 
+// A TweakLimiter is a TweakableHash that only accepts tweaks up to a certain
+// size.
+type TweakLimiter interface {
+	MaxTweakSize() int
+}
+
+// A Wiper is a primitive that can erase its key material from memory. After
+// calling Wipe, the primitive must not be used.
+type Wiper interface {
+	Wipe()
+}
+
 // HBSH is a cipher using the HBSH encryption mode.
 type HBSH struct {
 	stream StreamCipher
@@ -24,6 +55,11 @@ type HBSH struct {
 	thash  TweakableHash
 
 	hashBuf [32]byte
+
+	// scratch space for EncryptSectors and DecryptSectors
+	tweaks [groupSectors][8]byte
+	msgs   [groupSectors][]byte
+	nonces [groupSectors][]byte
 }
 
 func (h *HBSH) streamXOR(nonce, msg []byte) []byte {
@@ -46,7 +82,8 @@ func (h *HBSH) decryptBlock(src []byte) []byte {
 }
 
 // Encrypt encrypts block using the specified tweak. The block must be at least
-// 16 bytes. The size of the tweak is restricted by the underlying primitives.
+// 16 bytes. The size of the tweak is restricted by the underlying primitives;
+// see MaxTweakSize.
 func (h *HBSH) Encrypt(block, tweak []byte) []byte {
 	pl, pr := block[:len(block)-16], block[len(block)-16:]
 	pm := blockAdd(pr, h.hash(tweak, pl))
@@ -57,7 +94,8 @@ func (h *HBSH) Encrypt(block, tweak []byte) []byte {
 }
 
 // Decrypt decrypts block using the specified tweak. The block must be at least
-// 16 bytes. The size of the tweak is restricted by the underlying primitives.
+// 16 bytes. The size of the tweak is restricted by the underlying primitives;
+// see MaxTweakSize.
 func (h *HBSH) Decrypt(block, tweak []byte) []byte {
 	cl, cr := block[:len(block)-16], block[len(block)-16:]
 	cm := blockAdd(cr, h.hash(tweak, cl))
@@ -67,6 +105,65 @@ func (h *HBSH) Decrypt(block, tweak []byte) []byte {
 	return append(pl, pr...)
 }
 
+// Clone returns a new cipher that shares h's primitives, for use in another
+// goroutine. An HBSH is not safe for concurrent use, but its primitives must
+// be, since they are shared by Clone and by EncryptSectors; the primitives in
+// this module are.
+func (h *HBSH) Clone() *HBSH {
+	return New(h.stream, h.block, h.thash)
+}
+
+// Wipe erases the key material of each of h's primitives that implements
+// Wiper, after which h must not be used. It reports whether every primitive
+// was wiped; crypto/aes ciphers, for example, cannot be.
+func (h *HBSH) Wipe() bool {
+	wiped := true
+	for _, p := range []interface{}{h.stream, h.block, h.thash} {
+		if w, ok := p.(Wiper); ok {
+			w.Wipe()
+		} else {
+			wiped = false
+		}
+	}
+	return wiped
+}
+
+// MaxTweakSize returns the maximum tweak size supported by the cipher, or -1 if
+// the hash does not restrict the tweak size.
+func (h *HBSH) MaxTweakSize() int {
+	if tl, ok := h.thash.(TweakLimiter); ok {
+		return tl.MaxTweakSize()
+	}
+	return -1
+}
+
+func (h *HBSH) check(block, tweak []byte) error {
+	if len(block) < 16 {
+		return ErrBlockTooShort
+	} else if max := h.MaxTweakSize(); max >= 0 && len(tweak) > max {
+		return ErrTweakTooLong
+	}
+	return nil
+}
+
+// EncryptChecked is like Encrypt, but returns an error instead of exceeding the
+// limits of the cipher.
+func (h *HBSH) EncryptChecked(block, tweak []byte) ([]byte, error) {
+	if err := h.check(block, tweak); err != nil {
+		return nil, err
+	}
+	return h.Encrypt(block, tweak), nil
+}
+
+// DecryptChecked is like Decrypt, but returns an error instead of exceeding the
+// limits of the cipher.
+func (h *HBSH) DecryptChecked(block, tweak []byte) ([]byte, error) {
+	if err := h.check(block, tweak); err != nil {
+		return nil, err
+	}
+	return h.Decrypt(block, tweak), nil
+}
+
 // New returns an HBSH cipher using the specified primitives.
 func New(stream StreamCipher, block cipher.Block, hash TweakableHash) *HBSH {
 	return &HBSH{
@@ -2,10 +2,55 @@ package hbsh
 
 import (
 	"bytes"
+	"crypto/aes"
 	"crypto/rand"
 	"testing"
 )
 
+type nopStream struct{}
+
+func (nopStream) XORKeyStream(msg, nonce []byte) {}
+
+type limitedHash struct{ max int }
+
+func (h limitedHash) Sum(dst, src, tweak []byte) []byte { return append(dst, make([]byte, 16)...) }
+func (h limitedHash) MaxTweakSize() int                 { return h.max }
+
+type unlimitedHash struct{}
+
+func (unlimitedHash) Sum(dst, src, tweak []byte) []byte { return append(dst, make([]byte, 16)...) }
+
+type tweakRecorder func(tweak []byte)
+
+func (r tweakRecorder) Sum(dst, src, tweak []byte) []byte {
+	r(append([]byte(nil), tweak...))
+	return append(dst, make([]byte, 16)...)
+}
+
+func TestChecked(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 32))
+	h := New(nopStream{}, block, limitedHash{4})
+	if h.MaxTweakSize() != 4 {
+		t.Fatal("wrong MaxTweakSize:", h.MaxTweakSize())
+	}
+	if _, err := h.EncryptChecked(make([]byte, 16), make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	} else if _, err := h.EncryptChecked(make([]byte, 16), make([]byte, 5)); err != ErrTweakTooLong {
+		t.Fatal("expected ErrTweakTooLong, got", err)
+	} else if _, err := h.DecryptChecked(make([]byte, 16), make([]byte, 5)); err != ErrTweakTooLong {
+		t.Fatal("expected ErrTweakTooLong, got", err)
+	} else if _, err := h.DecryptChecked(make([]byte, 15), nil); err != ErrBlockTooShort {
+		t.Fatal("expected ErrBlockTooShort, got", err)
+	}
+
+	h = New(nopStream{}, block, unlimitedHash{})
+	if h.MaxTweakSize() != -1 {
+		t.Fatal("expected unlimited MaxTweakSize, got", h.MaxTweakSize())
+	} else if _, err := h.EncryptChecked(make([]byte, 16), make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestBlockAdd(t *testing.T) {
 	testCases := []struct {
 		desc string
@@ -115,3 +160,50 @@ func BenchmarkBlockSub(b *testing.B) {
 		blockSub(x, y)
 	}
 }
+
+func TestWithTweakFallback(t *testing.T) {
+	// a hash that does not implement TweakPrecomputer still receives the tweak
+	var got []byte
+	block, _ := aes.NewCipher(make([]byte, 32))
+	h := New(nopStream{}, block, tweakRecorder(func(tweak []byte) { got = tweak }))
+	tweak := []byte("tweak")
+	tc := h.WithTweak(tweak)
+	tweak[0] = 'T'
+	tc.Encrypt(make([]byte, 32))
+	if string(got) != "tweak" {
+		t.Fatalf("hash received tweak %q, expected %q", got, "tweak")
+	}
+}
+
+type wipeStream struct{ wiped bool }
+
+func (s *wipeStream) XORKeyStream(msg, nonce []byte) {}
+func (s *wipeStream) Wipe()                          { s.wiped = true }
+
+func TestWipe(t *testing.T) {
+	// crypto/aes cannot be wiped, so Wipe reports failure, but still wipes the
+	// stream
+	block, _ := aes.NewCipher(make([]byte, 32))
+	s := new(wipeStream)
+	h := New(s, block, unlimitedHash{})
+	if h.Wipe() {
+		t.Error("Wipe reported success with unwipeable primitives")
+	} else if !s.wiped {
+		t.Error("stream was not wiped")
+	}
+}
+
+func TestClone(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 32))
+	h := New(nopStream{}, block, unlimitedHash{})
+	c := h.Clone()
+	if c == h {
+		t.Fatal("Clone returned the same cipher")
+	}
+	msg := make([]byte, 64)
+	rand.Read(msg)
+	want := h.Encrypt(append([]byte(nil), msg...), nil)
+	if got := c.Encrypt(append([]byte(nil), msg...), nil); !bytes.Equal(got, want) {
+		t.Fatal("clone encrypted differently")
+	}
+}
@@ -0,0 +1,111 @@
+package hbsh
+
+import (
+	"runtime"
+	"sync"
+
+	"lukechampine.com/adiantum/hbsh/tweak"
+)
+
+// EncryptSectors encrypts buf in place as a sequence of consecutive sectors,
+// starting at firstSector. Each sector is encrypted using its index, encoded
+// with tweak.LE64, as the tweak. len(buf) must be a multiple of
+// sectorSize, and sectorSize must be at least 16.
+//
+// The sectors are processed in parallel, using up to GOMAXPROCS goroutines.
+// This requires the underlying primitives to be safe for concurrent use, which
+// is true of all the primitives in this module. If the stream cipher is a
+// MultiStreamCipher, each goroutine also processes its sectors in groups.
+func (h *HBSH) EncryptSectors(buf []byte, sectorSize int, firstSector uint64) {
+	h.processSectors(buf, sectorSize, firstSector, true)
+}
+
+// DecryptSectors decrypts buf in place as a sequence of consecutive sectors,
+// starting at firstSector. It is the inverse of EncryptSectors.
+func (h *HBSH) DecryptSectors(buf []byte, sectorSize int, firstSector uint64) {
+	h.processSectors(buf, sectorSize, firstSector, false)
+}
+
+func (h *HBSH) processSectors(buf []byte, sectorSize int, firstSector uint64, encrypt bool) {
+	if sectorSize < 16 {
+		panic("hbsh: sector size must be at least 16 bytes")
+	} else if len(buf)%sectorSize != 0 {
+		panic("hbsh: buffer must be a multiple of the sector size")
+	}
+	numSectors := len(buf) / sectorSize
+	workers := runtime.GOMAXPROCS(0)
+	if workers > numSectors {
+		workers = numSectors
+	}
+	if workers <= 1 {
+		h.processSectorRange(buf, sectorSize, firstSector, encrypt)
+		return
+	}
+
+	// split the sectors into contiguous ranges, one per worker; each worker
+	// needs its own HBSH, since the hash buffer is not safe for concurrent use
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		start := numSectors * i / workers
+		end := numSectors * (i + 1) / workers
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			w := h.Clone()
+			w.processSectorRange(buf[start*sectorSize:end*sectorSize], sectorSize, firstSector+uint64(start), encrypt)
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// groupSectors is the number of sectors passed to a MultiStreamCipher at once.
+const groupSectors = 8
+
+func (h *HBSH) processSectorRange(buf []byte, sectorSize int, firstSector uint64, encrypt bool) {
+	ms, ok := h.stream.(MultiStreamCipher)
+	if !ok {
+		for i := 0; len(buf) > 0; i++ {
+			t := tweak.LE64.AppendTweak(h.tweaks[0][:0], firstSector+uint64(i))
+			if encrypt {
+				h.Encrypt(buf[:sectorSize], t)
+			} else {
+				h.Decrypt(buf[:sectorSize], t)
+			}
+			buf = buf[sectorSize:]
+		}
+		return
+	}
+
+	// Split Encrypt and Decrypt into three phases: everything before the
+	// stream cipher, the stream cipher, and everything after it. The middle
+	// phase is performed for the whole group at once.
+	tweaks, msgs, nonces := &h.tweaks, &h.msgs, &h.nonces
+	for len(buf) > 0 {
+		n := len(buf) / sectorSize
+		if n > groupSectors {
+			n = groupSectors
+		}
+		for i := 0; i < n; i++ {
+			tweak.LE64.AppendTweak(tweaks[i][:0], firstSector+uint64(i))
+			sector := buf[i*sectorSize:][:sectorSize]
+			l, r := sector[:sectorSize-16], sector[sectorSize-16:]
+			if encrypt {
+				h.encryptBlock(blockAdd(r, h.hash(tweaks[i][:], l)))
+			} else {
+				blockAdd(r, h.hash(tweaks[i][:], l))
+			}
+			msgs[i], nonces[i] = l, r
+		}
+		ms.XORKeyStreams(msgs[:n], nonces[:n])
+		for i := 0; i < n; i++ {
+			l, r := msgs[i], nonces[i]
+			if encrypt {
+				blockSub(r, h.hash(tweaks[i][:], l))
+			} else {
+				blockSub(h.decryptBlock(r), h.hash(tweaks[i][:], l))
+			}
+		}
+		buf = buf[n*sectorSize:]
+		firstSector += uint64(n)
+	}
+}
@@ -0,0 +1,111 @@
+// Package tweak provides standard encodings for HBSH tweaks.
+//
+// HBSH ciphers accept arbitrary byte strings as tweaks, so two parties that
+// encode the same sector number differently will silently produce different
+// ciphertexts. The encodings in this package give names to the common
+// conventions.
+//
+// The maximum tweak length depends on the cipher. Adiantum tweaks may be at
+// most 32 bytes; the Linux kernel always uses exactly 32 bytes. HPolyC encodes
+// the tweak length as a 32-bit count of bits, so its tweaks must be shorter
+// than 2^29 bytes. Every encoding in this package fits within 32 bytes.
+package tweak // import "lukechampine.com/adiantum/hbsh/tweak"
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// A SectorEncoding encodes a sector number as a tweak.
+type SectorEncoding interface {
+	// AppendTweak appends the tweak for the specified sector to dst and
+	// returns the extended slice. It does not allocate if dst has sufficient
+	// capacity.
+	AppendTweak(dst []byte, sector uint64) []byte
+	// Size returns the length of the tweaks produced by AppendTweak.
+	Size() int
+}
+
+type le64 struct{}
+
+func (le64) AppendTweak(dst []byte, sector uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], sector)
+	return append(dst, buf[:]...)
+}
+
+func (le64) Size() int { return 8 }
+
+type be64 struct{}
+
+func (be64) AppendTweak(dst []byte, sector uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], sector)
+	return append(dst, buf[:]...)
+}
+
+func (be64) Size() int { return 8 }
+
+type plain64 struct{}
+
+func (plain64) AppendTweak(dst []byte, sector uint64) []byte {
+	var buf [32]byte
+	binary.LittleEndian.PutUint64(buf[:8], sector)
+	return append(dst, buf[:]...)
+}
+
+func (plain64) Size() int { return 32 }
+
+var (
+	// LE64 encodes the sector number as an 8-byte little-endian integer. This
+	// is the encoding used by hbsh.HBSH.EncryptSectors.
+	LE64 SectorEncoding = le64{}
+
+	// BE64 encodes the sector number as an 8-byte big-endian integer.
+	BE64 SectorEncoding = be64{}
+
+	// Plain64 encodes the sector number as dm-crypt's plain64 IV generator
+	// does for Adiantum: an 8-byte little-endian integer, padded with zeros to
+	// the cipher's 32-byte IV size. Use this to interoperate with volumes
+	// created by cryptsetup with "xchacha12,aes-adiantum-plain64".
+	Plain64 SectorEncoding = plain64{}
+)
+
+// SectorNonce encodes a sector number along with a 16-byte nonce, in the style
+// of fscrypt's DIRECT_KEY policies: the 8-byte little-endian sector (logical
+// block) number, followed by the nonce, padded with zeros to 32 bytes.
+type SectorNonce [16]byte
+
+// AppendTweak implements SectorEncoding.
+func (n SectorNonce) AppendTweak(dst []byte, sector uint64) []byte {
+	var buf [32]byte
+	binary.LittleEndian.PutUint64(buf[:8], sector)
+	copy(buf[8:24], n[:])
+	return append(dst, buf[:]...)
+}
+
+// Size implements SectorEncoding.
+func (n SectorNonce) Size() int { return 32 }
+
+// ErrContextTooLong is returned by AppendContext when the encoded context
+// would exceed 32 bytes.
+var ErrContextTooLong = errors.New("tweak: context exceeds 32 bytes")
+
+// AppendContext appends a structured context tweak to dst: each field,
+// prefixed with its length as a single byte. The encoding is unambiguous, so
+// distinct field lists always produce distinct tweaks. Since the result must
+// fit in an Adiantum tweak, the total encoded length may not exceed 32 bytes.
+func AppendContext(dst []byte, fields ...[]byte) ([]byte, error) {
+	var n int
+	for _, f := range fields {
+		n += 1 + len(f)
+	}
+	if n > 32 {
+		return dst, ErrContextTooLong
+	}
+	for _, f := range fields {
+		dst = append(dst, byte(len(f)))
+		dst = append(dst, f...)
+	}
+	return dst, nil
+}
@@ -0,0 +1,53 @@
+package tweak
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSectorEncodings(t *testing.T) {
+	nonce := SectorNonce{0: 0xAA, 15: 0xBB}
+	tests := []struct {
+		enc SectorEncoding
+		exp string
+	}{
+		{LE64, "0201000000000000"},
+		{BE64, "0000000000000102"},
+		{Plain64, "0201000000000000" + "000000000000000000000000000000000000000000000000"},
+		{nonce, "0201000000000000" + "aa0000000000000000000000000000bb" + "0000000000000000"},
+	}
+	for _, test := range tests {
+		got := test.enc.AppendTweak([]byte{0xFF}, 0x0102)
+		if hex.EncodeToString(got) != "ff"+test.exp {
+			t.Errorf("expected ff%v, got %x", test.exp, got)
+		} else if len(got)-1 != test.enc.Size() {
+			t.Errorf("Size() = %v, but tweak is %v bytes", test.enc.Size(), len(got)-1)
+		}
+		buf := make([]byte, 0, 32)
+		if n := testing.AllocsPerRun(10, func() { test.enc.AppendTweak(buf, 7) }); n > 0 {
+			t.Errorf("AppendTweak allocated %v times", n)
+		}
+	}
+}
+
+func TestAppendContext(t *testing.T) {
+	a, err := AppendContext(nil, []byte("ab"), []byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := AppendContext(nil, []byte("a"), []byte("bc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Fatal("different field lists produced the same tweak")
+	} else if !bytes.Equal(a, []byte("\x02ab\x01c")) {
+		t.Fatalf("unexpected encoding %q", a)
+	}
+	if _, err := AppendContext(nil, make([]byte, 31)); err != nil {
+		t.Fatal(err)
+	} else if _, err := AppendContext(nil, make([]byte, 32)); err != ErrContextTooLong {
+		t.Fatal("expected ErrContextTooLong, got", err)
+	}
+}
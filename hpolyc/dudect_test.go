@@ -0,0 +1,53 @@
+//go:build dudect
+// +build dudect
+
+package hpolyc
+
+import (
+	"flag"
+	"testing"
+
+	"lukechampine.com/adiantum/internal/dudect"
+)
+
+var dudectSamples = flag.Int("dudect.samples", 200000, "number of timing measurements per target")
+
+// TestConstantTime checks that the running time of each target does not depend
+// on its secret input. It only runs with the dudect build tag:
+//
+//	go test -tags dudect -run ConstantTime -v ./hpolyc
+func TestConstantTime(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	c := New(key)
+	_, _, hash := makeHPolyC(key, Config{})
+	tweak := make([]byte, 32)
+	fixed := make([]byte, 4096)
+	for i := range fixed {
+		fixed[i] = byte(i * 7)
+	}
+	dst := make([]byte, 0, 16)
+
+	targets := []struct {
+		name  string
+		fixed []byte
+		reps  int
+		fn    func([]byte)
+	}{
+		{"Encrypt", fixed, 1, func(b []byte) { c.Encrypt(b, tweak) }},
+		{"Decrypt", fixed, 1, func(b []byte) { c.Decrypt(b, tweak) }},
+		{"Hash", fixed, 1, func(b []byte) { hash.Sum(dst, b, tweak) }},
+		{"Hash/Tweak", fixed[:32], 16, func(b []byte) { hash.Sum(dst, nil, b) }},
+	}
+	for _, tt := range targets {
+		t.Run(tt.name, func(t *testing.T) {
+			r := dudect.Run(dudect.Config{Fixed: tt.fixed, Samples: *dudectSamples, Reps: tt.reps}, tt.fn)
+			t.Logf("%v samples, t = %.2f (percentile %.3f)", r.Samples, r.T, r.Percentile)
+			if r.Leaky() {
+				t.Errorf("timing depends on input: t = %.2f exceeds %v", r.T, dudect.Threshold)
+			}
+		})
+	}
+}
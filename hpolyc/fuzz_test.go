@@ -0,0 +1,66 @@
+package hpolyc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"testing"
+
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/reference"
+)
+
+func FuzzHPolyCHash(f *testing.F) {
+	f.Add([]byte("key"), []byte("message"), []byte("tweak"))
+	f.Add(bytes.Repeat([]byte{0xFF}, 16), bytes.Repeat([]byte{0xFF}, 1000), bytes.Repeat([]byte{0xFF}, 100))
+	f.Fuzz(func(t *testing.T, keySeed, msg, tweak []byte) {
+		var polyKey [32]byte
+		copy(polyKey[:16], keySeed)
+		h := hbsh.NewPoly1305Hash(polyKey[:16])
+		exp := reference.HPolyCHash(polyKey[:16], tweak, msg)
+		if got := h.Sum(nil, msg, tweak); !bytes.Equal(got, exp) {
+			t.Fatalf("hash mismatch:\nexp: %x\ngot: %x", exp, got)
+		}
+	})
+}
+
+func FuzzHPolyC(f *testing.F) {
+	f.Add([]byte("key"), []byte("tweak"), make([]byte, 16), uint8(0))
+	f.Add([]byte("key"), make([]byte, 100), make([]byte, 4096), uint8(1))
+	f.Add([]byte{}, []byte{}, make([]byte, 1100), uint8(2))
+	f.Fuzz(func(t *testing.T, keySeed, tweak, plaintext []byte, variant uint8) {
+		if len(plaintext) < 16 {
+			plaintext = append(plaintext, make([]byte, 16-len(plaintext))...)
+		}
+		key := make([]byte, 32)
+		copy(key, keySeed)
+		rounds := []int{8, 12, 20}[variant%3]
+		ref := reference.HPolyC(key, rounds)
+		c := []func([]byte) *hbsh.HBSH{New8, New, New20}[variant%3](key)
+
+		exp := ref.Encrypt(plaintext, tweak)
+		ciphertext := c.Encrypt(append([]byte(nil), plaintext...), tweak)
+		if !bytes.Equal(ciphertext, exp) {
+			t.Fatalf("Encrypt mismatch:\nexp: %x\ngot: %x", exp, ciphertext)
+		}
+		if got := ref.Decrypt(ciphertext, tweak); !bytes.Equal(got, plaintext) {
+			t.Fatal("reference decryption did not recover plaintext")
+		}
+		if got := c.Decrypt(append([]byte(nil), ciphertext...), tweak); !bytes.Equal(got, plaintext) {
+			t.Fatal("Decrypt did not recover plaintext")
+		}
+
+		// flipping any single bit should scramble the entire ciphertext
+		bit := int(binary.LittleEndian.Uint16(append(keySeed, 0, 0))) % (8 * len(plaintext))
+		flipped := append([]byte(nil), plaintext...)
+		flipped[bit/8] ^= 1 << (bit % 8)
+		flippedCiphertext := c.Encrypt(flipped, tweak)
+		var diff int
+		for i := range ciphertext {
+			diff += bits.OnesCount8(ciphertext[i] ^ flippedCiphertext[i])
+		}
+		if diff < 8*len(ciphertext)/4 {
+			t.Fatalf("flipping bit %v changed only %v of %v ciphertext bits", bit, diff, 8*len(ciphertext))
+		}
+	})
+}
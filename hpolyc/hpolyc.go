@@ -1,30 +1,23 @@
 package hpolyc // import "lukechampine.com/adiantum/hpolyc"
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
-	"encoding/binary"
 
-	"golang.org/x/crypto/poly1305"
 	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/internal/hbshconfig"
 	"lukechampine.com/adiantum/internal/xchacha"
 )
 
-type hpolycHash struct {
-	key [32]byte
-}
-
-func (h *hpolycHash) Sum(dst, msg, tweak []byte) []byte {
-	lenbuf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(lenbuf, uint32(8*len(tweak)))
-	padding := make([]byte, 16)[(4+len(tweak))%16:]
-	mac := poly1305.New(&h.key)
-	mac.Write(lenbuf)
-	mac.Write(tweak)
-	mac.Write(padding)
-	mac.Write(msg)
-	return mac.Sum(dst)
-}
+// MaxTweakSize is the maximum size of an HPolyC tweak. HPolyC hashes the tweak
+// T together with the message M as
+//
+//	Poly1305(len(T) || T || pad || M)
+//
+// where len(T) is the length of T in bits, encoded as a 32-bit little-endian
+// integer, and pad is 16 - (4+len(T)) mod 16 zero bytes, which align M to a
+// 16-byte boundary (a whole block if 4+len(T) is a multiple of 16). The
+// length must fit in 32 bits, so the tweak must be shorter than 2^29 bytes.
+const MaxTweakSize = 1<<29 - 1
 
 type chachaStream struct {
 	key    []byte
@@ -32,40 +25,86 @@ type chachaStream struct {
 }
 
 func (s *chachaStream) XORKeyStream(msg, nonce []byte) {
-	nonceBuf := make([]byte, 24)
-	n := copy(nonceBuf, nonce)
+	var nonceBuf [24]byte
+	n := copy(nonceBuf[:], nonce)
 	nonceBuf[n] = 1
-	xchacha.XORKeyStream(msg, msg, nonceBuf, s.key, s.rounds)
+	xchacha.XORKeyStream(msg, msg, nonceBuf[:], s.key, s.rounds)
+}
+
+// Wipe implements hbsh.Wiper.
+func (s *chachaStream) Wipe() {
+	for i := range s.key {
+		s.key[i] = 0
+	}
+}
+
+// chachaLanes adds hbsh.MultiStreamCipher to a chachaStream.
+type chachaLanes struct {
+	*chachaStream
 }
 
-func makeHPolyC(key []byte, chachaRounds int) (hbsh.StreamCipher, cipher.Block, hbsh.TweakableHash) {
-	if len(key) != xchacha.KeySize {
-		panic("hpolyc: key must be 32 bytes long")
+// withLanes wraps s in chachaLanes only if xchacha.HasLanes, as in package
+// adiantum.
+func withLanes(s *chachaStream) hbsh.StreamCipher {
+	if xchacha.HasLanes {
+		return chachaLanes{s}
 	}
+	return s
+}
+
+func (s chachaLanes) XORKeyStreams(msgs, nonces [][]byte) {
+	var nonceBufs [8][24]byte
+	var nonceSlices [8][]byte
+	for len(msgs) > 0 {
+		n := len(msgs)
+		if n > len(nonceBufs) {
+			n = len(nonceBufs)
+		}
+		for i := 0; i < n; i++ {
+			nonceBufs[i] = [24]byte{}
+			c := copy(nonceBufs[i][:], nonces[i])
+			nonceBufs[i][c] = 1
+			nonceSlices[i] = nonceBufs[i][:]
+		}
+		xchacha.XORKeyStreams(msgs[:n], nonceSlices[:n], s.key, s.rounds)
+		msgs, nonces = msgs[n:], nonces[n:]
+	}
+}
+
+// A Config specifies the parameters of an HPolyC variant.
+type Config = hbshconfig.Config
+
+func makeHPolyC(key []byte, c Config) (hbsh.StreamCipher, cipher.Block, hbsh.TweakableHash) {
+	c = hbshconfig.Check("hpolyc", key, c)
 	// create stream cipher and derive block+hash keys
-	stream := &chachaStream{key, chachaRounds}
-	keyBuf := make([]byte, 48)
+	stream := &chachaStream{append([]byte(nil), key...), c.Rounds}
+	keyBuf := make([]byte, c.BlockKeySize+16)
 	stream.XORKeyStream(keyBuf, nil)
-	block, _ := aes.NewCipher(keyBuf[:32])
-	hash := new(hpolycHash)
-	copy(hash.key[:16], keyBuf[32:])
-	return stream, block, hash
+	block := hbshconfig.NewBlock("hpolyc", c, keyBuf[:c.BlockKeySize])
+	return withLanes(stream), block, hbsh.NewPoly1305Hash(keyBuf[c.BlockKeySize:])
 }
 
 // New8 returns an HPolyC cipher with the specified key, using XChaCha8 as the
 // stream cipher. The key must be 32 bytes long.
 func New8(key []byte) *hbsh.HBSH {
-	return hbsh.New(makeHPolyC(key, 8))
+	return hbsh.New(makeHPolyC(key, Config{Rounds: 8}))
 }
 
 // New returns an HPolyC cipher with the specified key. The key must be 32 bytes
 // long.
 func New(key []byte) *hbsh.HBSH {
-	return hbsh.New(makeHPolyC(key, 12))
+	return hbsh.New(makeHPolyC(key, Config{Rounds: 12}))
 }
 
 // New20 returns an HPolyC cipher with the specified key, using XChaCha20 as the
 // stream cipher. The key must be 32 bytes long.
 func New20(key []byte) *hbsh.HBSH {
-	return hbsh.New(makeHPolyC(key, 20))
+	return hbsh.New(makeHPolyC(key, Config{Rounds: 20}))
+}
+
+// NewWithConfig returns an HPolyC variant with the specified key and
+// parameters. The key must be 32 bytes long. The block cipher key is derived
+// first, followed by the hash key, so the zero Config is equivalent to New.
+func NewWithConfig(key []byte, c Config) *hbsh.HBSH {
+	return hbsh.New(makeHPolyC(key, c))
 }
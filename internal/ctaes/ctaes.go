@@ -0,0 +1,399 @@
+// Package ctaes implements AES in constant time.
+//
+// The implementation is bitsliced: the state is stored as eight 16-bit planes,
+// where plane i holds bit i of each of the 16 state bytes, and SubBytes is
+// computed with the Boyar-Peralta circuit rather than a table lookup. It is
+// much slower than hardware AES, but its running time and memory access
+// pattern are independent of the key and data, unlike the table-based
+// fallback in crypto/aes.
+package ctaes // import "lukechampine.com/adiantum/internal/ctaes"
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"math/bits"
+	"strconv"
+)
+
+// BlockSize is the AES block size in bytes.
+const BlockSize = 16
+
+// KeySizeError is returned by NewCipher for invalid key sizes.
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+	return "ctaes: invalid key size " + strconv.Itoa(int(k))
+}
+
+type state [8]uint16
+
+type aesCipher struct {
+	rk []state
+}
+
+// NewCipher returns a constant-time AES cipher.Block. The key must be 16, 24,
+// or 32 bytes long, selecting AES-128, AES-192, or AES-256.
+func NewCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, KeySizeError(len(key))
+	}
+	words := expandKey(key)
+	c := &aesCipher{rk: make([]state, len(words)/4)}
+	var buf [16]byte
+	for i := range c.rk {
+		for j := 0; j < 4; j++ {
+			binary.BigEndian.PutUint32(buf[4*j:], words[4*i+j])
+		}
+		c.rk[i] = load(&buf)
+	}
+	for i := range words {
+		words[i] = 0
+	}
+	return c, nil
+}
+
+// Wipe zeroes the round keys of c, after which c must not be used. It
+// implements hbsh.Wiper.
+func (c *aesCipher) Wipe() {
+	for i := range c.rk {
+		c.rk[i] = state{}
+	}
+}
+
+func (c *aesCipher) BlockSize() int { return BlockSize }
+
+func (c *aesCipher) Encrypt(dst, src []byte) {
+	if len(src) < BlockSize || len(dst) < BlockSize {
+		panic("ctaes: input not full block")
+	}
+	q := load((*[16]byte)(src))
+	q.addRoundKey(&c.rk[0])
+	rounds := len(c.rk) - 1
+	for i := 1; i < rounds; i++ {
+		q.subBytes()
+		q.shiftRows()
+		q.mixColumns()
+		q.addRoundKey(&c.rk[i])
+	}
+	q.subBytes()
+	q.shiftRows()
+	q.addRoundKey(&c.rk[rounds])
+	q.store((*[16]byte)(dst))
+}
+
+func (c *aesCipher) Decrypt(dst, src []byte) {
+	if len(src) < BlockSize || len(dst) < BlockSize {
+		panic("ctaes: input not full block")
+	}
+	q := load((*[16]byte)(src))
+	rounds := len(c.rk) - 1
+	q.addRoundKey(&c.rk[rounds])
+	for i := rounds - 1; i > 0; i-- {
+		q.invShiftRows()
+		q.invSubBytes()
+		q.addRoundKey(&c.rk[i])
+		q.invMixColumns()
+	}
+	q.invShiftRows()
+	q.invSubBytes()
+	q.addRoundKey(&c.rk[0])
+	q.store((*[16]byte)(dst))
+}
+
+// transpose transposes x as an 8x8 bit matrix whose rows are its bytes.
+func transpose(x uint64) uint64 {
+	t := (x ^ x>>7) & 0x00AA00AA00AA00AA
+	x ^= t ^ t<<7
+	t = (x ^ x>>14) & 0x0000CCCC0000CCCC
+	x ^= t ^ t<<14
+	t = (x ^ x>>28) & 0x00000000F0F0F0F0
+	x ^= t ^ t<<28
+	return x
+}
+
+// load bitslices a block: bit j of plane i is bit i of byte j.
+func load(b *[16]byte) (q state) {
+	lo := transpose(binary.LittleEndian.Uint64(b[:8]))
+	hi := transpose(binary.LittleEndian.Uint64(b[8:]))
+	for i := range q {
+		q[i] = uint16(byte(lo>>(8*i))) | uint16(byte(hi>>(8*i)))<<8
+	}
+	return
+}
+
+func (q *state) store(b *[16]byte) {
+	var lo, hi uint64
+	for i := range q {
+		lo |= uint64(byte(q[i])) << (8 * i)
+		hi |= uint64(q[i]>>8) << (8 * i)
+	}
+	binary.LittleEndian.PutUint64(b[:8], transpose(lo))
+	binary.LittleEndian.PutUint64(b[8:], transpose(hi))
+}
+
+func (q *state) addRoundKey(rk *state) {
+	for i := range q {
+		q[i] ^= rk[i]
+	}
+}
+
+// Byte j of the state holds row j%4 of column j/4, so shifting row r left by r
+// columns rotates its bits right by 4r positions.
+const (
+	row0 = 0x1111
+	row1 = 0x2222
+	row2 = 0x4444
+	row3 = 0x8888
+)
+
+func (q *state) shiftRows() {
+	for i, x := range q {
+		q[i] = x&row0 |
+			bits.RotateLeft16(x&row1, -4) |
+			bits.RotateLeft16(x&row2, -8) |
+			bits.RotateLeft16(x&row3, -12)
+	}
+}
+
+func (q *state) invShiftRows() {
+	for i, x := range q {
+		q[i] = x&row0 |
+			bits.RotateLeft16(x&row1, 4) |
+			bits.RotateLeft16(x&row2, 8) |
+			bits.RotateLeft16(x&row3, 12)
+	}
+}
+
+// rotRows1, rotRows2, and rotRows3 move row (r+n)%4 of each column into row r,
+// for n = 1, 2, and 3.
+func rotRows1(x uint16) uint16 { return (x>>1)&0x7777 | (x&0x1111)<<3 }
+func rotRows2(x uint16) uint16 { return (x>>2)&0x3333 | (x&0x3333)<<2 }
+func rotRows3(x uint16) uint16 { return (x>>3)&0x1111 | (x&0x7777)<<1 }
+
+// xtime multiplies each byte of q by x in GF(2^8).
+func (q *state) xtime() {
+	hi := q[7]
+	q[7] = q[6]
+	q[6] = q[5]
+	q[5] = q[4]
+	q[4] = q[3] ^ hi
+	q[3] = q[2] ^ hi
+	q[2] = q[1]
+	q[1] = q[0] ^ hi
+	q[0] = hi
+}
+
+func (q *state) mixColumns() {
+	// b_r = 2a_r + 3a_{r+1} + a_{r+2} + a_{r+3}
+	//     = 2(a_r + a_{r+1}) + a_{r+1} + a_{r+2} + a_{r+3}
+	var t, u state
+	for i, x := range q {
+		r1, r2, r3 := rotRows1(x), rotRows2(x), rotRows3(x)
+		t[i] = x ^ r1
+		u[i] = r1 ^ r2 ^ r3
+	}
+	t.xtime()
+	for i := range q {
+		q[i] = t[i] ^ u[i]
+	}
+}
+
+func (q *state) invMixColumns() {
+	// InvMixColumns is MixColumns preceded by a_r += 4(a_r + a_{r+2})
+	var t state
+	for i, x := range q {
+		t[i] = x ^ rotRows2(x)
+	}
+	t.xtime()
+	t.xtime()
+	for i := range q {
+		q[i] ^= t[i]
+	}
+	q.mixColumns()
+}
+
+// subBytes applies the AES S-box to each byte of q, using the circuit from
+// "A depth-16 circuit for the AES S-box" by Boyar and Peralta.
+func (q *state) subBytes() {
+	x0, x1, x2, x3, x4, x5, x6, x7 := q[7], q[6], q[5], q[4], q[3], q[2], q[1], q[0]
+
+	// top linear transformation
+	y14 := x3 ^ x5
+	y13 := x0 ^ x6
+	y9 := x0 ^ x3
+	y8 := x0 ^ x5
+	t0 := x1 ^ x2
+	y1 := t0 ^ x7
+	y4 := y1 ^ x3
+	y12 := y13 ^ y14
+	y2 := y1 ^ x0
+	y5 := y1 ^ x6
+	y3 := y5 ^ y8
+	t1 := x4 ^ y12
+	y15 := t1 ^ x5
+	y20 := t1 ^ x1
+	y6 := y15 ^ x7
+	y10 := y15 ^ t0
+	y11 := y20 ^ y9
+	y7 := x7 ^ y11
+	y17 := y10 ^ y11
+	y19 := y10 ^ y8
+	y16 := t0 ^ y11
+	y21 := y13 ^ y16
+	y18 := x0 ^ y16
+
+	// non-linear section
+	t2 := y12 & y15
+	t3 := y3 & y6
+	t4 := t3 ^ t2
+	t5 := y4 & x7
+	t6 := t5 ^ t2
+	t7 := y13 & y16
+	t8 := y5 & y1
+	t9 := t8 ^ t7
+	t10 := y2 & y7
+	t11 := t10 ^ t7
+	t12 := y9 & y11
+	t13 := y14 & y17
+	t14 := t13 ^ t12
+	t15 := y8 & y10
+	t16 := t15 ^ t12
+	t17 := t4 ^ t14
+	t18 := t6 ^ t16
+	t19 := t9 ^ t14
+	t20 := t11 ^ t16
+	t21 := t17 ^ y20
+	t22 := t18 ^ y19
+	t23 := t19 ^ y21
+	t24 := t20 ^ y18
+
+	t25 := t21 ^ t22
+	t26 := t21 & t23
+	t27 := t24 ^ t26
+	t28 := t25 & t27
+	t29 := t28 ^ t22
+	t30 := t23 ^ t24
+	t31 := t22 ^ t26
+	t32 := t31 & t30
+	t33 := t32 ^ t24
+	t34 := t23 ^ t33
+	t35 := t27 ^ t33
+	t36 := t24 & t35
+	t37 := t36 ^ t34
+	t38 := t27 ^ t36
+	t39 := t29 & t38
+	t40 := t25 ^ t39
+
+	t41 := t40 ^ t37
+	t42 := t29 ^ t33
+	t43 := t29 ^ t40
+	t44 := t33 ^ t37
+	t45 := t42 ^ t41
+	z0 := t44 & y15
+	z1 := t37 & y6
+	z2 := t33 & x7
+	z3 := t43 & y16
+	z4 := t40 & y1
+	z5 := t29 & y7
+	z6 := t42 & y11
+	z7 := t45 & y17
+	z8 := t41 & y10
+	z9 := t44 & y12
+	z10 := t37 & y3
+	z11 := t33 & y4
+	z12 := t43 & y13
+	z13 := t40 & y5
+	z14 := t29 & y2
+	z15 := t42 & y9
+	z16 := t45 & y14
+	z17 := t41 & y8
+
+	// bottom linear transformation
+	t46 := z15 ^ z16
+	t47 := z10 ^ z11
+	t48 := z5 ^ z13
+	t49 := z9 ^ z10
+	t50 := z2 ^ z12
+	t51 := z2 ^ z5
+	t52 := z7 ^ z8
+	t53 := z0 ^ z3
+	t54 := z6 ^ z7
+	t55 := z16 ^ z17
+	t56 := z12 ^ t48
+	t57 := t50 ^ t53
+	t58 := z4 ^ t46
+	t59 := z3 ^ t54
+	t60 := t46 ^ t57
+	t61 := z14 ^ t57
+	t62 := t52 ^ t58
+	t63 := t49 ^ t58
+	t64 := z4 ^ t59
+	t65 := t61 ^ t62
+	t66 := z1 ^ t63
+	s0 := t59 ^ t63
+	s6 := t56 ^ ^t62
+	s7 := t48 ^ ^t60
+	t67 := t64 ^ t65
+	s3 := t53 ^ t66
+	s4 := t51 ^ t66
+	s5 := t47 ^ t65
+	s1 := t64 ^ ^s3
+	s2 := t55 ^ ^t67
+
+	q[7], q[6], q[5], q[4], q[3], q[2], q[1], q[0] = s0, s1, s2, s3, s4, s5, s6, s7
+}
+
+// invAffine applies the inverse of the S-box's affine transformation:
+// b_i = a_{i+2} + a_{i+5} + a_{i+7} + 0x05_i.
+func (q *state) invAffine() {
+	a0, a1, a2, a3, a4, a5, a6, a7 := q[0], q[1], q[2], q[3], q[4], q[5], q[6], q[7]
+	q[0] = ^(a2 ^ a5 ^ a7)
+	q[1] = a3 ^ a6 ^ a0
+	q[2] = ^(a4 ^ a7 ^ a1)
+	q[3] = a5 ^ a0 ^ a2
+	q[4] = a6 ^ a1 ^ a3
+	q[5] = a7 ^ a2 ^ a4
+	q[6] = a0 ^ a3 ^ a5
+	q[7] = a1 ^ a4 ^ a6
+}
+
+// invSubBytes applies the inverse S-box. Since S(x) = A(x^-1) for the affine
+// map A, S^-1(y) = (A^-1(y))^-1 = A^-1(S(A^-1(y))).
+func (q *state) invSubBytes() {
+	q.invAffine()
+	q.subBytes()
+	q.invAffine()
+}
+
+// subWord applies the S-box to each byte of w.
+func subWord(w uint32) uint32 {
+	var b [16]byte
+	binary.BigEndian.PutUint32(b[:], w)
+	q := load(&b)
+	q.subBytes()
+	q.store(&b)
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// expandKey returns the AES key schedule as 4(rounds+1) big-endian words.
+func expandKey(key []byte) []uint32 {
+	nk := len(key) / 4
+	w := make([]uint32, 4*(nk+7))
+	for i := 0; i < nk; i++ {
+		w[i] = binary.BigEndian.Uint32(key[4*i:])
+	}
+	rcon := uint32(1)
+	for i := nk; i < len(w); i++ {
+		t := w[i-1]
+		if i%nk == 0 {
+			t = subWord(bits.RotateLeft32(t, 8)) ^ rcon<<24
+			rcon = rcon<<1 ^ 0x11b&-(rcon>>7)
+		} else if nk > 6 && i%nk == 4 {
+			t = subWord(t)
+		}
+		w[i] = w[i-nk] ^ t
+	}
+	return w
+}
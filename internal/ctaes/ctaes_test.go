@@ -0,0 +1,120 @@
+package ctaes
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+func TestFIPS197(t *testing.T) {
+	// FIPS-197, Appendix C
+	plaintext, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+	for _, test := range []struct {
+		key, ciphertext string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "69c4e0d86a7b0430d8cdb78070b4c55a"},
+		{"000102030405060708090a0b0c0d0e0f1011121314151617", "dda97ca4864cdfe06eaf70a0ec0d7191"},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "8ea2b7ca516745bfeafc49904b496089"},
+	} {
+		key, _ := hex.DecodeString(test.key)
+		exp, _ := hex.DecodeString(test.ciphertext)
+		c, err := NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		c.Encrypt(buf, plaintext)
+		if !bytes.Equal(buf, exp) {
+			t.Fatalf("AES-%v: Encrypt mismatch:\nexp: %x\ngot: %x", 8*len(key), exp, buf)
+		}
+		c.Decrypt(buf, buf)
+		if !bytes.Equal(buf, plaintext) {
+			t.Fatalf("AES-%v: Decrypt mismatch:\nexp: %x\ngot: %x", 8*len(key), plaintext, buf)
+		}
+	}
+}
+
+func TestMatchesStdlib(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		key := make([]byte, []int{16, 24, 32}[i%3])
+		block := make([]byte, 16)
+		r.Read(key)
+		r.Read(block)
+		std, _ := aes.NewCipher(key)
+		ct, err := NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, got := make([]byte, 16), make([]byte, 16)
+		std.Encrypt(exp, block)
+		ct.Encrypt(got, block)
+		if !bytes.Equal(got, exp) {
+			t.Fatalf("AES-%v: Encrypt mismatch for key %x, block %x", 8*len(key), key, block)
+		}
+		std.Decrypt(exp, block)
+		ct.Decrypt(got, block)
+		if !bytes.Equal(got, exp) {
+			t.Fatalf("AES-%v: Decrypt mismatch for key %x, block %x", 8*len(key), key, block)
+		}
+	}
+}
+
+func TestKeySize(t *testing.T) {
+	for _, n := range []int{0, 15, 17, 31, 33} {
+		if _, err := NewCipher(make([]byte, n)); err != KeySizeError(n) {
+			t.Errorf("expected KeySizeError(%v), got %v", n, err)
+		}
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	c, _ := NewCipher(make([]byte, 32))
+	buf := make([]byte, 16)
+	b.SetBytes(16)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Encrypt(buf, buf)
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	c, _ := NewCipher(make([]byte, 32))
+	buf := make([]byte, 16)
+	b.SetBytes(16)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Decrypt(buf, buf)
+	}
+}
+
+func TestNewAES(t *testing.T) {
+	defer func(f bool) { Force = f }(Force)
+	key := make([]byte, 32)
+	for _, f := range []bool{false, true} {
+		Force = f
+		b, err := NewAES(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, isCT := b.(*aesCipher)
+		if exp := f || !hasHardwareAES; isCT != exp {
+			t.Errorf("Force = %v, hardware = %v: expected constant-time implementation %v, got %v", f, hasHardwareAES, exp, isCT)
+		}
+	}
+}
+
+func TestWipe(t *testing.T) {
+	block, err := NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.(interface{ Wipe() }).Wipe()
+	for i, rk := range block.(*aesCipher).rk {
+		if rk != (state{}) {
+			t.Fatalf("round key %v was not wiped", i)
+		}
+	}
+}
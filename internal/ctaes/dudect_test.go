@@ -0,0 +1,40 @@
+//go:build dudect
+// +build dudect
+
+package ctaes
+
+import (
+	"flag"
+	"testing"
+
+	"lukechampine.com/adiantum/internal/dudect"
+)
+
+var dudectSamples = flag.Int("dudect.samples", 200000, "number of timing measurements per target")
+
+// TestConstantTime checks that the running time of the bitsliced cipher does
+// not depend on its input. It only runs with the dudect build tag.
+func TestConstantTime(t *testing.T) {
+	fixed := make([]byte, 16)
+	for i := range fixed {
+		fixed[i] = byte(i * 7)
+	}
+	c, _ := NewCipher(make([]byte, 32))
+	targets := []struct {
+		name string
+		fn   func([]byte)
+	}{
+		{"Encrypt", func(b []byte) { c.Encrypt(b, b) }},
+		{"Decrypt", func(b []byte) { c.Decrypt(b, b) }},
+		{"KeySchedule", func(b []byte) { NewCipher(append(b, b...)) }},
+	}
+	for _, tt := range targets {
+		t.Run(tt.name, func(t *testing.T) {
+			r := dudect.Run(dudect.Config{Fixed: fixed, Samples: *dudectSamples, Reps: 4}, tt.fn)
+			t.Logf("%v samples, t = %.2f (percentile %.3f)", r.Samples, r.T, r.Percentile)
+			if r.Leaky() {
+				t.Errorf("timing depends on input: t = %.2f exceeds %v", r.T, dudect.Threshold)
+			}
+		})
+	}
+}
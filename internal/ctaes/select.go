@@ -0,0 +1,22 @@
+package ctaes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"os"
+)
+
+// Force selects the constant-time implementation even when hardware AES is
+// available. It defaults to true if the ADIANTUM_AES environment variable is
+// "ct", and tests may set it to exercise the fallback on any machine.
+var Force = os.Getenv("ADIANTUM_AES") == "ct"
+
+// NewAES returns an AES cipher.Block for key. It uses crypto/aes when the CPU
+// has AES instructions, in which case crypto/aes runs in constant time, and the
+// bitsliced implementation otherwise.
+func NewAES(key []byte) (cipher.Block, error) {
+	if hasHardwareAES && !Force {
+		return aes.NewCipher(key)
+	}
+	return NewCipher(key)
+}
@@ -0,0 +1,8 @@
+//go:build amd64
+// +build amd64
+
+package ctaes
+
+import "golang.org/x/sys/cpu"
+
+var hasHardwareAES = cpu.X86.HasAES
@@ -0,0 +1,8 @@
+//go:build arm64
+// +build arm64
+
+package ctaes
+
+import "golang.org/x/sys/cpu"
+
+var hasHardwareAES = cpu.ARM64.HasAES
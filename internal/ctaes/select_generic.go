@@ -0,0 +1,6 @@
+//go:build !amd64 && !arm64 && !s390x
+// +build !amd64,!arm64,!s390x
+
+package ctaes
+
+const hasHardwareAES = false
@@ -0,0 +1,8 @@
+//go:build s390x
+// +build s390x
+
+package ctaes
+
+import "golang.org/x/sys/cpu"
+
+var hasHardwareAES = cpu.S390X.HasAES
@@ -0,0 +1,157 @@
+// Package dudect implements the statistical timing test described in "Dude, is
+// my code constant time?" by Reparaz, Balasch, and Verbauwhede.
+//
+// The function under test is timed on two classes of inputs: a fixed input,
+// and uniformly random inputs. The classes are interleaved randomly, and
+// Welch's t-test is applied to the resulting timing distributions, both in full
+// and cropped at several percentiles to suppress measurement noise. A large
+// t-statistic is evidence that the function's running time depends on its
+// input.
+package dudect // import "lukechampine.com/adiantum/internal/dudect"
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Threshold is the t-statistic above which a function is considered to leak
+// timing information. The original dudect uses the same value.
+const Threshold = 10
+
+// A Welch accumulates samples from two classes and computes Welch's t-statistic
+// for the difference of their means.
+type Welch struct {
+	n    [2]float64
+	mean [2]float64
+	m2   [2]float64
+}
+
+// Add adds a sample to the specified class (0 or 1).
+func (w *Welch) Add(class int, x float64) {
+	w.n[class]++
+	delta := x - w.mean[class]
+	w.mean[class] += delta / w.n[class]
+	w.m2[class] += delta * (x - w.mean[class])
+}
+
+// T returns Welch's t-statistic for the samples added so far.
+func (w *Welch) T() float64 {
+	if w.n[0] < 2 || w.n[1] < 2 {
+		return 0
+	}
+	v0 := w.m2[0] / (w.n[0] - 1)
+	v1 := w.m2[1] / (w.n[1] - 1)
+	den := math.Sqrt(v0/w.n[0] + v1/w.n[1])
+	if den == 0 {
+		return 0
+	}
+	return (w.mean[0] - w.mean[1]) / den
+}
+
+// A Result summarizes a timing test.
+type Result struct {
+	Samples int
+	// T is the t-statistic with the largest magnitude across all percentile
+	// crops, and Percentile is the crop at which it occurred (1 means
+	// uncropped).
+	T          float64
+	Percentile float64
+}
+
+// Leaky reports whether the t-statistic exceeds Threshold.
+func (r Result) Leaky() bool {
+	return math.Abs(r.T) > Threshold
+}
+
+// A Config describes a timing test.
+type Config struct {
+	// Fixed is the input used for the fixed class. Random inputs have the same
+	// length.
+	Fixed []byte
+	// Samples is the number of measurements to take.
+	Samples int
+	// Reps is the number of times fn is called per measurement; increase it
+	// for functions that are fast relative to the clock resolution. A zero
+	// value is treated as 1.
+	Reps int
+	// Seed seeds the generator for class assignment and random inputs.
+	Seed int64
+}
+
+// percentiles are the crops applied to the measurements: none, followed by
+// dudect's 1 - 0.5^(k+1) schedule.
+var percentiles = func() []float64 {
+	ps := []float64{1}
+	for k := 0; k < 10; k++ {
+		ps = append(ps, 1-math.Pow(0.5, float64(k+1)))
+	}
+	return ps
+}()
+
+// batchSize is the number of inputs prepared before each batch of
+// measurements. Preparing inputs outside the measurement loop keeps the cost
+// of generating random inputs from skewing the timings.
+const batchSize = 1024
+
+// Run times fn on the fixed and random input classes described by c and
+// returns the resulting t-statistic.
+func Run(c Config, fn func(input []byte)) Result {
+	reps := c.Reps
+	if reps == 0 {
+		reps = 1
+	}
+	rng := rand.New(rand.NewSource(c.Seed))
+	batch := make([][]byte, batchSize)
+	for i := range batch {
+		batch[i] = make([]byte, len(c.Fixed))
+	}
+	input := make([]byte, len(c.Fixed))
+	classes := make([]int, c.Samples)
+	times := make([]float64, c.Samples)
+	for i := 0; i < c.Samples; i += batchSize {
+		n := c.Samples - i
+		if n > batchSize {
+			n = batchSize
+		}
+		for j := 0; j < n; j++ {
+			classes[i+j] = rng.Intn(2)
+			if classes[i+j] == 0 {
+				copy(batch[j], c.Fixed)
+			} else {
+				rng.Read(batch[j])
+			}
+		}
+		for j := 0; j < n; j++ {
+			// fn may modify its input, so always pass it a copy; for
+			// the fixed class, repeated calls still see a fixed input
+			copy(input, batch[j])
+			start := time.Now()
+			for k := 0; k < reps; k++ {
+				fn(input)
+			}
+			times[i+j] = float64(time.Since(start))
+		}
+	}
+	return analyze(classes, times)
+}
+
+func analyze(classes []int, times []float64) Result {
+	sorted := append([]float64(nil), times...)
+	sort.Float64s(sorted)
+	r := Result{Samples: len(times)}
+	for _, p := range percentiles {
+		cutoff := sorted[int(p*float64(len(sorted)-1))]
+		var w Welch
+		for i, t := range times {
+			if t <= cutoff {
+				w.Add(classes[i], t)
+			}
+		}
+		if t := w.T(); math.Abs(t) > math.Abs(r.T) {
+			r.T, r.Percentile = t, p
+		}
+	}
+	return r
+}
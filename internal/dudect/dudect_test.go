@@ -0,0 +1,46 @@
+package dudect
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWelch(t *testing.T) {
+	var w Welch
+	for _, x := range []float64{1, 2, 3, 4} {
+		w.Add(0, x)
+	}
+	for _, x := range []float64{2, 4, 6, 8} {
+		w.Add(1, x)
+	}
+	// means 2.5 and 5, variances 5/3 and 20/3
+	exp := -2.5 / math.Sqrt((5.0/3)/4+(20.0/3)/4)
+	if got := w.T(); math.Abs(got-exp) > 1e-12 {
+		t.Fatalf("expected t = %v, got %v", exp, got)
+	}
+
+	var empty Welch
+	if empty.T() != 0 {
+		t.Fatal("expected t = 0 with no samples")
+	}
+}
+
+func TestRunLeaky(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	// an early-exit comparison against the fixed input takes far longer on the
+	// fixed class than on random inputs
+	secret := make([]byte, 4096)
+	var sink int
+	r := Run(Config{Fixed: secret, Samples: 20000}, func(input []byte) {
+		n := 0
+		for n < len(input) && input[n] == secret[n] {
+			n++
+		}
+		sink += n
+	})
+	if !r.Leaky() {
+		t.Fatalf("failed to detect leak: t = %.2f", r.T)
+	}
+}
@@ -0,0 +1,67 @@
+// Package hbshconfig holds the parameters shared by the XChaCha-based HBSH
+// ciphers, Adiantum and HPolyC, which re-export Config under their own names.
+package hbshconfig // import "lukechampine.com/adiantum/internal/hbshconfig"
+
+import (
+	"crypto/cipher"
+
+	"lukechampine.com/adiantum/internal/ctaes"
+	"lukechampine.com/adiantum/internal/xchacha"
+)
+
+// A Config specifies the parameters of an Adiantum or HPolyC variant.
+type Config struct {
+	// Rounds is the number of XChaCha rounds: 8, 12, or 20. If zero, 12 is
+	// used.
+	Rounds int
+	// BlockKeySize is the size, in bytes, of the block cipher key derived from
+	// the master key. With the default block cipher, AES, it must be 16, 24, or
+	// 32. If zero, 32 (AES-256) is used.
+	BlockKeySize int
+	// NewBlock, if non-nil, is used in place of AES to construct the block
+	// cipher from BlockKeySize bytes of derived key material. The cipher must
+	// have a 16-byte block size.
+	NewBlock func(key []byte) (cipher.Block, error)
+	// ConstantTimeAES selects the bitsliced constant-time AES even on CPUs
+	// with AES instructions, where crypto/aes would otherwise be used. It is
+	// ignored if NewBlock is set.
+	ConstantTimeAES bool
+}
+
+// Check validates key and c, and fills in the defaults of c. Its panic
+// messages are prefixed with pkg, the name of the calling package.
+func Check(pkg string, key []byte, c Config) Config {
+	if len(key) != xchacha.KeySize {
+		panic(pkg + ": key must be 32 bytes long")
+	}
+	if c.Rounds == 0 {
+		c.Rounds = 12
+	} else if c.Rounds != 8 && c.Rounds != 12 && c.Rounds != 20 {
+		panic(pkg + ": rounds must be 8, 12, or 20")
+	}
+	if c.BlockKeySize == 0 {
+		c.BlockKeySize = 32
+	} else if c.BlockKeySize < 0 {
+		panic(pkg + ": invalid block cipher key size")
+	}
+	if c.NewBlock == nil {
+		if c.ConstantTimeAES {
+			c.NewBlock = ctaes.NewCipher
+		} else {
+			c.NewBlock = ctaes.NewAES
+		}
+	}
+	return c
+}
+
+// NewBlock constructs the block cipher of c, which must have been returned by
+// Check, from key. Like Check, it panics with messages prefixed with pkg.
+func NewBlock(pkg string, c Config, key []byte) cipher.Block {
+	block, err := c.NewBlock(key)
+	if err != nil {
+		panic(pkg + ": " + err.Error())
+	} else if block.BlockSize() != 16 {
+		panic(pkg + ": block cipher must have a 16-byte block size")
+	}
+	return block
+}
@@ -0,0 +1,37 @@
+package hbshconfig
+
+import (
+	"crypto/des"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	c := Check("test", make([]byte, 32), Config{})
+	if c.Rounds != 12 || c.BlockKeySize != 32 || c.NewBlock == nil {
+		t.Fatalf("defaults not filled in: %+v", c)
+	}
+	if b := NewBlock("test", c, make([]byte, 32)); b.BlockSize() != 16 {
+		t.Fatal("default block cipher has wrong block size")
+	}
+}
+
+func TestPanics(t *testing.T) {
+	key := make([]byte, 32)
+	for name, fn := range map[string]func(){
+		"key size":   func() { Check("test", key[:16], Config{}) },
+		"rounds":     func() { Check("test", key, Config{Rounds: 10}) },
+		"block key":  func() { Check("test", key, Config{BlockKeySize: -1}) },
+		"AES key":    func() { NewBlock("test", Check("test", key, Config{}), key[:7]) },
+		"block size": func() { NewBlock("test", Check("test", key, Config{NewBlock: des.NewCipher}), key[:8]) },
+	} {
+		func() {
+			defer func() {
+				if r, ok := recover().(string); !ok || !strings.HasPrefix(r, "test: ") {
+					t.Errorf("%v: expected panic prefixed with package name, got %v", name, r)
+				}
+			}()
+			fn()
+		}()
+	}
+}
@@ -0,0 +1,162 @@
+// Package kat reads, writes, and generates known-answer test vectors in the
+// JSON schema used by github.com/google/adiantum.
+package kat // import "lukechampine.com/adiantum/internal/kat"
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"lukechampine.com/adiantum/reference"
+)
+
+// HexBytes is a byte slice that is encoded in JSON as a hex string.
+type HexBytes []byte
+
+// MarshalText implements encoding.TextMarshaler.
+func (b HexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (b *HexBytes) UnmarshalText(text []byte) error {
+	dec, err := hex.DecodeString(string(text))
+	*b = dec
+	return err
+}
+
+// Lengths describes the sizes, in bytes, of a cipher's parameters.
+type Lengths struct {
+	Block int `json:"block,omitempty"`
+	Key   int `json:"key,omitempty"`
+	Nonce int `json:"nonce,omitempty"`
+}
+
+// A CipherSpec describes a cipher and its components.
+type CipherSpec struct {
+	Cipher       string      `json:"cipher"`
+	Rounds       int         `json:"rounds,omitempty"`
+	StreamCipher *CipherSpec `json:"streamcipher,omitempty"`
+	// The misspelling is part of the upstream schema.
+	DelegateVariant *CipherSpec `json:"delgatevariant,omitempty"`
+	BlockCipher     *CipherSpec `json:"blockcipher,omitempty"`
+	Lengths         Lengths     `json:"lengths"`
+}
+
+// Spec returns the CipherSpec for Adiantum or HPolyC with the specified number
+// of XChaCha rounds and AES key size in bytes.
+func Spec(cipher string, rounds, aesKeySize int) CipherSpec {
+	return CipherSpec{
+		Cipher: cipher,
+		StreamCipher: &CipherSpec{
+			Cipher: "XChaCha",
+			Rounds: rounds,
+			DelegateVariant: &CipherSpec{
+				Cipher:  "ChaCha",
+				Rounds:  rounds,
+				Lengths: Lengths{Key: 32, Nonce: 8},
+			},
+			Lengths: Lengths{Key: 32, Nonce: 24},
+		},
+		BlockCipher: &CipherSpec{
+			Cipher:  "AES",
+			Lengths: Lengths{Block: 16, Key: aesKeySize},
+		},
+		Lengths: Lengths{Key: 32},
+	}
+}
+
+// Input holds the key and tweak of a Vector.
+type Input struct {
+	Key   HexBytes `json:"key_hex"`
+	Tweak HexBytes `json:"tweak_hex"`
+}
+
+// A Vector is a single known-answer test.
+type Vector struct {
+	Cipher      CipherSpec `json:"cipher"`
+	Description string     `json:"description"`
+	Input       Input      `json:"input"`
+	Plaintext   HexBytes   `json:"plaintext_hex"`
+	Ciphertext  HexBytes   `json:"ciphertext_hex"`
+}
+
+// ReadFile decodes the JSON file at filename into v.
+func ReadFile(filename string, v interface{}) error {
+	js, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, v)
+}
+
+// Load reads the vectors in the specified file.
+func Load(filename string) ([]Vector, error) {
+	var vs []Vector
+	err := ReadFile(filename, &vs)
+	return vs, err
+}
+
+// Write writes vs to w, formatted like the upstream vector files.
+func Write(w io.Writer, vs []Vector) error {
+	js, err := json.MarshalIndent(vs, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(js)
+	return err
+}
+
+// Generate returns a vector for each combination of plaintext and tweak
+// length, using keys and messages read from rand and ciphertexts computed by
+// the reference implementation of spec.
+func Generate(spec CipherSpec, rand io.Reader, lengths, tweakLengths []int) ([]Vector, error) {
+	var newCipher func([]byte, int, int) *reference.Cipher
+	switch spec.Cipher {
+	case "Adiantum":
+		newCipher = reference.AdiantumVariant
+	case "HPolyC":
+		newCipher = reference.HPolyCVariant
+	default:
+		return nil, fmt.Errorf("unknown cipher %q", spec.Cipher)
+	}
+	if spec.StreamCipher == nil {
+		return nil, fmt.Errorf("%v spec has no stream cipher", spec.Cipher)
+	} else if spec.BlockCipher == nil || spec.BlockCipher.Cipher != "AES" {
+		return nil, fmt.Errorf("%v spec has no AES block cipher", spec.Cipher)
+	}
+	switch spec.BlockCipher.Lengths.Key {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("invalid AES key size %v", spec.BlockCipher.Lengths.Key)
+	}
+
+	var vs []Vector
+	for _, n := range lengths {
+		if n < 16 {
+			return nil, fmt.Errorf("invalid plaintext length %v", n)
+		}
+		for _, tn := range tweakLengths {
+			v := Vector{
+				Cipher:      spec,
+				Description: fmt.Sprintf("Random (%2d)", len(vs)+1),
+				Input: Input{
+					Key:   make([]byte, 32),
+					Tweak: make([]byte, tn),
+				},
+				Plaintext: make([]byte, n),
+			}
+			for _, b := range [][]byte{v.Input.Key, v.Input.Tweak, v.Plaintext} {
+				if _, err := io.ReadFull(rand, b); err != nil {
+					return nil, err
+				}
+			}
+			c := newCipher(v.Input.Key, spec.StreamCipher.Rounds, spec.BlockCipher.Lengths.Key)
+			v.Ciphertext = c.Encrypt(v.Plaintext, v.Input.Tweak)
+			vs = append(vs, v)
+		}
+	}
+	return vs, nil
+}
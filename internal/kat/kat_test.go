@@ -0,0 +1,73 @@
+package kat
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+
+	"lukechampine.com/adiantum/reference"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, filename := range []string{
+		"../../testdata/Adiantum_XChaCha12_32_AES256.json",
+		"../../hpolyc/testdata/HPolyC_XChaCha12_32_AES256.json",
+	} {
+		js, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vs, err := Load(filename)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(vs) == 0 {
+			t.Fatal("no vectors loaded")
+		}
+		var buf bytes.Buffer
+		if err := Write(&buf, vs); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf.Bytes(), js) {
+			t.Errorf("%v: re-encoded vectors differ from original", filename)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	spec := Spec("Adiantum", 12, 32)
+	lengths, tweakLengths := []int{16, 17, 4096}, []int{0, 17, 32}
+	vs, err := Generate(spec, rand.New(rand.NewSource(0)), lengths, tweakLengths)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(vs) != len(lengths)*len(tweakLengths) {
+		t.Fatalf("expected %v vectors, got %v", len(lengths)*len(tweakLengths), len(vs))
+	}
+	for i, v := range vs {
+		if len(v.Plaintext) != lengths[i/len(tweakLengths)] || len(v.Input.Tweak) != tweakLengths[i%len(tweakLengths)] {
+			t.Fatalf("vector %v has wrong dimensions", i)
+		}
+		c := reference.Adiantum(v.Input.Key, 12)
+		if !bytes.Equal(c.Decrypt(v.Ciphertext, v.Input.Tweak), v.Plaintext) {
+			t.Fatalf("vector %v does not decrypt", i)
+		}
+	}
+
+	// generated specs should match the upstream files
+	upstream, err := Load("../../hpolyc/testdata/HPolyC_XChaCha8_32_AES256.json")
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(upstream[0].Cipher, Spec("HPolyC", 8, 32)) {
+		t.Error("Spec does not match upstream cipher description")
+	}
+
+	if _, err := Generate(Spec("XTS", 12, 32), rand.New(rand.NewSource(0)), lengths, tweakLengths); err == nil {
+		t.Error("expected error for unknown cipher")
+	}
+	if _, err := Generate(Spec("Adiantum", 12, 20), rand.New(rand.NewSource(0)), lengths, tweakLengths); err == nil {
+		t.Error("expected error for invalid AES key size")
+	}
+	if _, err := Generate(spec, rand.New(rand.NewSource(0)), []int{15}, tweakLengths); err == nil {
+		t.Error("expected error for short plaintext")
+	}
+}
@@ -0,0 +1,303 @@
+// Package poly1305 implements the Poly1305 one-time authenticator.
+//
+// Unlike golang.org/x/crypto/poly1305, this implementation separates the key
+// from the hash state. A Key stores r, r², r³, and r⁴, which allows the MAC to
+// process four blocks per step with a single reduction:
+//
+//	h' = (h + m₁)·r⁴ + m₂·r³ + m₃·r² + m₄·r
+//
+// Computing the powers costs three multiplications, so a Key should be reused
+// across messages, as HBSH does with its hash keys.
+//
+// Arithmetic is performed on three limbs of 44, 44, and 42 bits, following
+// poly1305-donna-64. On amd64 with AVX2, long messages are instead processed
+// four blocks at a time in vector lanes.
+package poly1305 // import "lukechampine.com/adiantum/internal/poly1305"
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// TagSize is the size of a Poly1305 tag.
+const TagSize = 16
+
+const (
+	mask44 = 1<<44 - 1
+	mask42 = 1<<42 - 1
+	mask26 = 1<<26 - 1
+)
+
+// An elem is an integer modulo 2^130-5, stored in three limbs of 44, 44, and 42
+// bits. Limbs may temporarily exceed their nominal width.
+type elem [3]uint64
+
+// A power is a power of r, along with 20 times its upper limbs, which are used
+// to fold the high part of a product back into the low part: since
+// 2^132 = 4·2^130 ≡ 20 mod p, a product term of weight 2^132 can be replaced
+// by 20 times the same term at weight 1.
+type power struct {
+	r  elem
+	s1 uint64
+	s2 uint64
+}
+
+type uint128 struct {
+	lo, hi uint64
+}
+
+func mul64(a, b uint64) uint128 {
+	hi, lo := bits.Mul64(a, b)
+	return uint128{lo, hi}
+}
+
+func add128(a, b uint128) uint128 {
+	lo, c := bits.Add64(a.lo, b.lo, 0)
+	hi, _ := bits.Add64(a.hi, b.hi, c)
+	return uint128{lo, hi}
+}
+
+func shiftRight(a uint128, n uint) uint64 {
+	return a.lo>>n | a.hi<<(64-n)
+}
+
+// mulAdd adds h·p to the unreduced product d.
+func mulAdd(d *[3]uint128, h *elem, p *power) {
+	d[0] = add128(d[0], add128(mul64(h[0], p.r[0]), add128(mul64(h[1], p.s2), mul64(h[2], p.s1))))
+	d[1] = add128(d[1], add128(mul64(h[0], p.r[1]), add128(mul64(h[1], p.r[0]), mul64(h[2], p.s2))))
+	d[2] = add128(d[2], add128(mul64(h[0], p.r[2]), add128(mul64(h[1], p.r[1]), mul64(h[2], p.r[0]))))
+}
+
+// reduce partially reduces the product d into h.
+func reduce(h *elem, d *[3]uint128) {
+	c := shiftRight(d[0], 44)
+	h[0] = d[0].lo & mask44
+	d[1] = add128(d[1], uint128{c, 0})
+	c = shiftRight(d[1], 44)
+	h[1] = d[1].lo & mask44
+	d[2] = add128(d[2], uint128{c, 0})
+	c = shiftRight(d[2], 42)
+	h[2] = d[2].lo & mask42
+	h[0] += c * 5
+	c = h[0] >> 44
+	h[0] &= mask44
+	h[1] += c
+}
+
+// toRadix26 converts h to five limbs of 26 bits.
+func toRadix26(h elem) [5]uint64 {
+	carry(&h)
+	return [5]uint64{
+		h[0] & mask26,
+		(h[0]>>26 | h[1]<<18) & mask26,
+		(h[1] >> 8) & mask26,
+		(h[1]>>34 | h[2]<<10) & mask26,
+		h[2] >> 16,
+	}
+}
+
+// fromRadix26 converts five limbs of 26 bits, each of which may exceed its
+// nominal width by a few bits, to an elem.
+func fromRadix26(l [5]uint64) elem {
+	c := l[0] >> 26
+	l[0] &= mask26
+	l[1] += c
+	c = l[1] >> 26
+	l[1] &= mask26
+	l[2] += c
+	c = l[2] >> 26
+	l[2] &= mask26
+	l[3] += c
+	c = l[3] >> 26
+	l[3] &= mask26
+	l[4] += c
+	c = l[4] >> 26
+	l[4] &= mask26
+	l[0] += c * 5
+
+	var h elem
+	v := l[0] + l[1]<<26
+	h[0] = v & mask44
+	v = v>>44 + l[2]<<8 + l[3]<<34
+	h[1] = v & mask44
+	h[2] = v>>44 + l[4]<<16
+	return h
+}
+
+// carry fully propagates the carries of h, so that each limb is within its
+// nominal width and h < 2^130.
+func carry(h *elem) {
+	for i := 0; i < 2; i++ {
+		c := h[1] >> 44
+		h[1] &= mask44
+		h[2] += c
+		c = h[2] >> 42
+		h[2] &= mask42
+		h[0] += c * 5
+		c = h[0] >> 44
+		h[0] &= mask44
+		h[1] += c
+	}
+}
+
+// loadBlock adds the 16-byte block b, with the specified high bit, to h.
+func loadBlock(h *elem, b []byte, hibit uint64) {
+	t0 := binary.LittleEndian.Uint64(b[0:8])
+	t1 := binary.LittleEndian.Uint64(b[8:16])
+	h[0] += t0 & mask44
+	h[1] += (t0>>44 | t1<<20) & mask44
+	h[2] += (t1>>24)&mask42 | hibit
+}
+
+func newPower(r *elem) power {
+	return power{r: *r, s1: r[1] * 20, s2: r[2] * 20}
+}
+
+// A Key is a Poly1305 key, with precomputed powers of r.
+type Key struct {
+	pow [4]power // r, r², r³, r⁴
+	pad [2]uint64
+	vec [2]vecKey
+}
+
+// Init initializes k with the specified 32-byte one-time key.
+func (k *Key) Init(key *[32]byte) {
+	t0 := binary.LittleEndian.Uint64(key[0:8]) & 0x0ffffffc0fffffff
+	t1 := binary.LittleEndian.Uint64(key[8:16]) & 0x0ffffffc0ffffffc
+	r := elem{t0 & mask44, (t0>>44 | t1<<20) & mask44, (t1 >> 24) & mask42}
+	k.pow[0] = newPower(&r)
+	for i := 1; i < len(k.pow); i++ {
+		var d [3]uint128
+		mulAdd(&d, &k.pow[i-1].r, &k.pow[0])
+		var ri elem
+		reduce(&ri, &d)
+		k.pow[i] = newPower(&ri)
+	}
+	k.pad[0] = binary.LittleEndian.Uint64(key[16:24])
+	k.pad[1] = binary.LittleEndian.Uint64(key[24:32])
+	initVecKey(k)
+}
+
+// A MAC is a Poly1305 hash state. The zero value is not usable; call Init
+// first.
+type MAC struct {
+	key *Key
+	h   elem
+	buf [64]byte
+	n   int
+}
+
+// Init initializes m with the specified key, discarding any previous state.
+// The key is not copied, and must not be modified while m is in use.
+func (m *MAC) Init(key *Key) {
+	*m = MAC{key: key}
+}
+
+// blocks processes full 16-byte blocks of p, four at a time where possible.
+func (m *MAC) blocks(p []byte) {
+	k := m.key
+	h := m.h
+	p = vecBlocks(&h, p, k)
+	for len(p) >= 64 {
+		loadBlock(&h, p[0:16], 1<<40)
+		var m2, m3, m4 elem
+		loadBlock(&m2, p[16:32], 1<<40)
+		loadBlock(&m3, p[32:48], 1<<40)
+		loadBlock(&m4, p[48:64], 1<<40)
+		var d [3]uint128
+		mulAdd(&d, &h, &k.pow[3])
+		mulAdd(&d, &m2, &k.pow[2])
+		mulAdd(&d, &m3, &k.pow[1])
+		mulAdd(&d, &m4, &k.pow[0])
+		reduce(&h, &d)
+		p = p[64:]
+	}
+	for len(p) >= 16 {
+		loadBlock(&h, p[:16], 1<<40)
+		var d [3]uint128
+		mulAdd(&d, &h, &k.pow[0])
+		reduce(&h, &d)
+		p = p[16:]
+	}
+	m.h = h
+}
+
+// Write adds more data to the running hash. It never returns an error.
+func (m *MAC) Write(p []byte) (int, error) {
+	n := len(p)
+	if m.n > 0 {
+		c := copy(m.buf[m.n:], p)
+		m.n += c
+		p = p[c:]
+		if m.n < len(m.buf) {
+			return n, nil
+		}
+		m.blocks(m.buf[:])
+		m.n = 0
+	}
+	if full := len(p) &^ 15; full > 0 {
+		m.blocks(p[:full])
+		p = p[full:]
+	}
+	m.n = copy(m.buf[:], p)
+	return n, nil
+}
+
+// Sum appends the tag of the data written so far to b and returns it. It does
+// not change the underlying hash state.
+func (m *MAC) Sum(b []byte) []byte {
+	mc := *m
+	var tag [TagSize]byte
+	mc.finish(&tag)
+	return append(b, tag[:]...)
+}
+
+func (m *MAC) finish(tag *[TagSize]byte) {
+	// process buffered full blocks, then the final partial block
+	full := m.n &^ 15
+	m.blocks(m.buf[:full])
+	h := m.h
+	if rem := m.buf[full:m.n]; len(rem) > 0 {
+		var final [16]byte
+		copy(final[:], rem)
+		final[len(rem)] = 1
+		loadBlock(&h, final[:], 0)
+		var d [3]uint128
+		mulAdd(&d, &h, &m.key.pow[0])
+		reduce(&h, &d)
+	}
+
+	carry(&h)
+
+	// compute g = h - p = h + 5 - 2^130, and select it if h >= p
+	g0 := h[0] + 5
+	c := g0 >> 44
+	g0 &= mask44
+	g1 := h[1] + c
+	c = g1 >> 44
+	g1 &= mask44
+	g2 := h[2] + c - 1<<42
+	mask := (g2 >> 63) - 1 // all ones if g2 did not underflow
+	h[0] = h[0]&^mask | g0&mask
+	h[1] = h[1]&^mask | g1&mask
+	h[2] = h[2]&^mask | g2&mask
+
+	// h = (h + pad) mod 2^128
+	h0 := h[0] | h[1]<<44
+	h1 := h[1]>>20 | h[2]<<24
+	var carry uint64
+	h0, carry = bits.Add64(h0, m.key.pad[0], 0)
+	h1, _ = bits.Add64(h1, m.key.pad[1], carry)
+	binary.LittleEndian.PutUint64(tag[0:8], h0)
+	binary.LittleEndian.PutUint64(tag[8:16], h1)
+}
+
+// Sum computes the Poly1305 tag of msg with the specified one-time key.
+func Sum(out *[TagSize]byte, msg []byte, key *[32]byte) {
+	var k Key
+	k.Init(key)
+	var m MAC
+	m.Init(&k)
+	m.Write(msg)
+	m.finish(out)
+}
@@ -0,0 +1,63 @@
+//go:build amd64
+// +build amd64
+
+package poly1305
+
+import "golang.org/x/sys/cpu"
+
+// A vecKey holds the powers of r used by blocksAVX2, in radix 2^26, with each
+// limb repeated (or, for the final step, varied) across four lanes.
+type vecKey struct {
+	r [5][4]uint64
+	s [4][4]uint64 // 5·r₁..5·r₄
+}
+
+//go:noescape
+func blocksAVX2(h *[5][4]uint64, msg []byte, key *[2]vecKey)
+
+// useAVX2 reports whether blocksAVX2 should be used.
+var useAVX2 = cpu.X86.HasAVX2
+
+// minVecLen is the shortest input for which blocksAVX2 outperforms the scalar
+// code.
+const minVecLen = 256
+
+func initVecKey(k *Key) {
+	// the main loop multiplies every lane by r⁴; the final step multiplies
+	// the lanes by r⁴, r², r³, and r
+	pows := [2][4]*elem{
+		{&k.pow[3].r, &k.pow[3].r, &k.pow[3].r, &k.pow[3].r},
+		{&k.pow[3].r, &k.pow[1].r, &k.pow[2].r, &k.pow[0].r},
+	}
+	for i := range pows {
+		for lane, p := range pows[i] {
+			r := toRadix26(*p)
+			for j := range r {
+				k.vec[i].r[j][lane] = r[j]
+			}
+			for j := 1; j < 5; j++ {
+				k.vec[i].s[j-1][lane] = r[j] * 5
+			}
+		}
+	}
+}
+
+// vecBlocks processes a prefix of p whose length is a multiple of 64 bytes,
+// returning the remainder.
+func vecBlocks(h *elem, p []byte, k *Key) []byte {
+	if !useAVX2 || len(p) < minVecLen {
+		return p
+	}
+	n := len(p) &^ 63
+	var lanes [5][4]uint64
+	r := toRadix26(*h)
+	for j := range r {
+		lanes[j][0] = r[j]
+	}
+	blocksAVX2(&lanes, p[:n], &k.vec)
+	for j := range r {
+		r[j] = lanes[j][0] + lanes[j][1] + lanes[j][2] + lanes[j][3]
+	}
+	*h = fromRadix26(r)
+	return p[n:]
+}
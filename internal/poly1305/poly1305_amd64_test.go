@@ -0,0 +1,13 @@
+package poly1305
+
+import "testing"
+
+func TestSumScalar(t *testing.T) {
+	if !useAVX2 {
+		t.Skip("AVX2 not available; TestSum already covers the scalar path")
+	}
+	useAVX2 = false
+	defer func() { useAVX2 = true }()
+	TestSum(t)
+	TestWrite(t)
+}
@@ -0,0 +1,148 @@
+// +build amd64,!gccgo,!appengine,!nacl
+
+#include "textflag.h"
+
+// Processes four blocks per iteration, one in each 64-bit lane of the YMM
+// registers. Each lane accumulates every fourth block, multiplying by r⁴ after
+// each step; the final step instead multiplies the lanes by r⁴, r², r³, and r
+// (matching the order in which VPUNPCKLQDQ distributes the blocks), so that the
+// sum of the lanes is the hash of the whole message. Arithmetic is performed on
+// five limbs of 26 bits, so that VPMULUDQ can compute products of limbs.
+
+DATA mask26<>+0x00(SB)/8, $0x3ffffff
+GLOBL mask26<>(SB), (NOPTR+RODATA), $8
+
+DATA hibit<>+0x00(SB)/8, $0x1000000
+GLOBL hibit<>(SB), (NOPTR+RODATA), $8
+
+#define H0 Y0
+#define H1 Y1
+#define H2 Y2
+#define H3 Y3
+#define H4 Y4
+#define D0 Y5
+#define D1 Y6
+#define D2 Y7
+#define D3 Y8
+#define D4 Y9
+#define T0 Y10
+#define T1 Y11
+#define T2 Y12
+#define T3 Y13
+#define MASK Y14
+#define HIBIT Y15
+
+// Layout of a vecKey (see poly1305_amd64.go), relative to a base register:
+// r₀..r₄ at 0..128, 5·r₁..5·r₄ at 160..256.
+
+#define MULACC(K, h, off, d) \
+	VPMULUDQ off(K), h, T0; \
+	VPADDQ   T0, d, d
+
+#define MUL(K) \
+	VPMULUDQ 0(K), H0, D0;  \
+	MULACC(K, H1, 256, D0)     \
+	MULACC(K, H2, 224, D0)     \
+	MULACC(K, H3, 192, D0)     \
+	MULACC(K, H4, 160, D0)     \
+	VPMULUDQ 32(K), H0, D1; \
+	MULACC(K, H1, 0, D1)       \
+	MULACC(K, H2, 256, D1)     \
+	MULACC(K, H3, 224, D1)     \
+	MULACC(K, H4, 192, D1)     \
+	VPMULUDQ 64(K), H0, D2; \
+	MULACC(K, H1, 32, D2)      \
+	MULACC(K, H2, 0, D2)       \
+	MULACC(K, H3, 256, D2)     \
+	MULACC(K, H4, 224, D2)     \
+	VPMULUDQ 96(K), H0, D3; \
+	MULACC(K, H1, 64, D3)      \
+	MULACC(K, H2, 32, D3)      \
+	MULACC(K, H3, 0, D3)       \
+	MULACC(K, H4, 256, D3)     \
+	VPMULUDQ 128(K), H0, D4; \
+	MULACC(K, H1, 96, D4)      \
+	MULACC(K, H2, 64, D4)      \
+	MULACC(K, H3, 32, D4)      \
+	MULACC(K, H4, 0, D4)
+
+#define CARRY(a, b) \
+	VPSRLQ $26, a, T0; \
+	VPAND  MASK, a, a; \
+	VPADDQ T0, b, b
+
+// REDUCE partially reduces D0..D4 into H0..H4.
+#define REDUCE \
+	CARRY(D0, D1)         \
+	CARRY(D1, D2)         \
+	CARRY(D2, D3)         \
+	CARRY(D3, D4)         \
+	VPSRLQ $26, D4, T0;   \
+	VPAND  MASK, D4, H4;  \
+	VPSLLQ $2, T0, T1;    \
+	VPADDQ T1, T0, T0;    \
+	VPADDQ T0, D0, D0;    \
+	CARRY(D0, D1)         \
+	VMOVDQU D0, H0;       \
+	VMOVDQU D1, H1;       \
+	VMOVDQU D2, H2;       \
+	VMOVDQU D3, H3
+
+// func blocksAVX2(h *[5][4]uint64, msg []byte, key *[2]vecKey)
+TEXT ·blocksAVX2(SB), NOSPLIT, $0-40
+	MOVQ h+0(FP), DI
+	MOVQ msg_base+8(FP), SI
+	MOVQ msg_len+16(FP), CX
+	MOVQ key+32(FP), BX
+	LEAQ 288(BX), R8
+
+	VPBROADCASTQ mask26<>(SB), MASK
+	VPBROADCASTQ hibit<>(SB), HIBIT
+	VMOVDQU 0(DI), H0
+	VMOVDQU 32(DI), H1
+	VMOVDQU 64(DI), H2
+	VMOVDQU 96(DI), H3
+	VMOVDQU 128(DI), H4
+
+LOOP:
+	// split four blocks into limbs and add them to h
+	VMOVDQU     0(SI), T0
+	VMOVDQU     32(SI), T1
+	VPUNPCKLQDQ T1, T0, T2
+	VPUNPCKHQDQ T1, T0, T3
+	VPAND       MASK, T2, T0
+	VPADDQ      T0, H0, H0
+	VPSRLQ      $26, T2, T0
+	VPAND       MASK, T0, T0
+	VPADDQ      T0, H1, H1
+	VPSRLQ      $52, T2, T0
+	VPSLLQ      $12, T3, T1
+	VPOR        T1, T0, T0
+	VPAND       MASK, T0, T0
+	VPADDQ      T0, H2, H2
+	VPSRLQ      $14, T3, T0
+	VPAND       MASK, T0, T0
+	VPADDQ      T0, H3, H3
+	VPSRLQ      $40, T3, T0
+	VPOR        HIBIT, T0, T0
+	VPADDQ      T0, H4, H4
+
+	ADDQ $64, SI
+	SUBQ $64, CX
+	JZ   LAST
+
+	MUL(BX)
+	REDUCE
+	JMP LOOP
+
+LAST:
+	MUL(R8)
+	REDUCE
+
+	VMOVDQU H0, 0(DI)
+	VMOVDQU H1, 32(DI)
+	VMOVDQU H2, 64(DI)
+	VMOVDQU H3, 96(DI)
+	VMOVDQU H4, 128(DI)
+	VZEROUPPER
+	RET
@@ -0,0 +1,113 @@
+package poly1305
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/crypto/poly1305"
+)
+
+func TestSum(t *testing.T) {
+	var key [32]byte
+	msg := make([]byte, 1100)
+	for i := 0; i < 2000; i++ {
+		rand.Read(key[:])
+		rand.Read(msg)
+		n := i % len(msg)
+		var exp, got [TagSize]byte
+		poly1305.Sum(&exp, msg[:n], &key)
+		Sum(&got, msg[:n], &key)
+		if got != exp {
+			t.Fatalf("Sum(%v bytes) mismatch:\nexp: %x\ngot: %x", n, exp, got)
+		}
+	}
+}
+
+func TestSumEdgeCases(t *testing.T) {
+	// keys and messages with extreme values, to exercise the carry and
+	// final reduction paths
+	var allOnes [32]byte
+	for i := range allOnes {
+		allOnes[i] = 0xFF
+	}
+	keys := [][32]byte{{}, allOnes}
+	msgs := [][]byte{nil, make([]byte, 16), bytes.Repeat([]byte{0xFF}, 16), bytes.Repeat([]byte{0xFF}, 256)}
+	// h = p - 1 before finalization: r = 1, m = 2^130 - 6 - 2^128
+	var one [32]byte
+	one[0] = 1
+	keys = append(keys, one)
+	pm1 := make([]byte, 16)
+	binary.LittleEndian.PutUint64(pm1[0:8], 0xFFFFFFFFFFFFFFFA)
+	binary.LittleEndian.PutUint64(pm1[8:16], 0xFFFFFFFFFFFFFFFF)
+	msgs = append(msgs, pm1, append(pm1, pm1...))
+	for _, key := range keys {
+		key := key
+		for _, msg := range msgs {
+			var exp, got [TagSize]byte
+			poly1305.Sum(&exp, msg, &key)
+			Sum(&got, msg, &key)
+			if got != exp {
+				t.Fatalf("Sum(%x) with key %x mismatch:\nexp: %x\ngot: %x", msg, key, exp, got)
+			}
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var key [32]byte
+	rand.Read(key[:])
+	var k Key
+	k.Init(&key)
+	msg := make([]byte, 1000)
+	rand.Read(msg)
+	var exp [TagSize]byte
+	poly1305.Sum(&exp, msg, &key)
+
+	// write in chunks of varying size
+	for chunk := 1; chunk < 100; chunk++ {
+		var m MAC
+		m.Init(&k)
+		for p := msg; len(p) > 0; {
+			n := chunk
+			if n > len(p) {
+				n = len(p)
+			}
+			m.Write(p[:n])
+			p = p[n:]
+		}
+		if got := m.Sum(nil); !bytes.Equal(got, exp[:]) {
+			t.Fatalf("chunk size %v: mismatch:\nexp: %x\ngot: %x", chunk, exp, got)
+		}
+		// Sum should not modify state
+		if got := m.Sum(nil); !bytes.Equal(got, exp[:]) {
+			t.Fatal("Sum modified MAC state")
+		}
+	}
+}
+
+func BenchmarkPoly1305(b *testing.B) {
+	var key [32]byte
+	rand.Read(key[:])
+	msg := make([]byte, 4096)
+	b.Run("x/crypto", func(b *testing.B) {
+		b.SetBytes(int64(len(msg)))
+		for i := 0; i < b.N; i++ {
+			var out [16]byte
+			poly1305.Sum(&out, msg, &key)
+		}
+	})
+	b.Run("Powers", func(b *testing.B) {
+		var k Key
+		k.Init(&key)
+		b.SetBytes(int64(len(msg)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var m MAC
+			m.Init(&k)
+			m.Write(msg)
+			m.Sum(nil)
+		}
+	})
+}
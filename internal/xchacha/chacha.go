@@ -1,6 +1,7 @@
 package xchacha
 
 import (
+	"crypto/cipher"
 	"encoding/binary"
 
 	"github.com/aead/chacha20/chacha"
@@ -24,6 +25,106 @@ func XORKeyStream(dst, src, nonce, key []byte, rounds int) {
 	chacha.XORKeyStream(dst, src, nonce[16:], tmpKey[:], rounds)
 }
 
+// NewCipher returns a cipher.Stream that generates the same key stream as
+// XORKeyStream, but incrementally: each call continues where the last one
+// stopped.
+func NewCipher(nonce, key []byte, rounds int) cipher.Stream {
+	s := new(Stream)
+	s.Init(nonce, key, rounds)
+	return s
+}
+
+// A Stream generates an XChaCha key stream incrementally, like the
+// cipher.Stream returned by NewCipher. Unlike a new cipher, it can be
+// reinitialized in place, so rekeying does not allocate. The zero Stream must
+// be initialized before use.
+type Stream struct {
+	state  [16]uint32 // block counter excluded
+	ctr    uint64
+	buf    [lanes][64]byte
+	off    int // bytes of buf already used
+	rounds int
+}
+
+// Init resets s to the start of the key stream derived from the key and nonce.
+func (s *Stream) Init(nonce, key []byte, rounds int) {
+	var subkey [32]byte
+	var hNonce [16]byte
+	copy(hNonce[:], nonce[:16])
+	copy(subkey[:], key)
+	hChaCha(&subkey, &hNonce, &subkey, rounds)
+	copy(s.state[:], sigma[:])
+	for i := 0; i < 8; i++ {
+		s.state[4+i] = binary.LittleEndian.Uint32(subkey[i*4:])
+	}
+	s.state[14] = binary.LittleEndian.Uint32(nonce[16:])
+	s.state[15] = binary.LittleEndian.Uint32(nonce[20:])
+	s.ctr = 0
+	s.off = len(s.buf) * 64
+	s.rounds = rounds
+	subkey = [32]byte{}
+}
+
+// XORKeyStream implements cipher.Stream.
+func (s *Stream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("xchacha: output smaller than input")
+	}
+	for len(src) > 0 {
+		if s.off == len(s.buf)*64 {
+			s.refill()
+		}
+		block := &s.buf[s.off/64]
+		if s.off%64 == 0 && len(src) >= 64 {
+			copy(dst, src[:64])
+			xorBlock(dst, block)
+			s.off += 64
+			src, dst = src[64:], dst[64:]
+			continue
+		}
+		rest := block[s.off%64:]
+		n := len(rest)
+		if n > len(src) {
+			n = len(src)
+		}
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ rest[i]
+		}
+		s.off += n
+		src, dst = src[n:], dst[n:]
+	}
+}
+
+// refill generates the next blocks of the key stream: lanes blocks in lockstep
+// if HasLanes, and otherwise one block, at the end of buf.
+func (s *Stream) refill() {
+	if !HasLanes {
+		state := s.state
+		state[12], state[13] = uint32(s.ctr), uint32(s.ctr>>32)
+		chachaBlock(&s.buf[lanes-1], &state, s.rounds)
+		s.ctr++
+		s.off = (lanes - 1) * 64
+		return
+	}
+	var state [16][lanes]uint32
+	for w := range s.state {
+		for i := range state[w] {
+			state[w][i] = s.state[w]
+		}
+	}
+	for i := range state[12] {
+		ctr := s.ctr + uint64(i)
+		state[12][i], state[13][i] = uint32(ctr), uint32(ctr>>32)
+	}
+	chachaLanes(&s.buf, &state, s.rounds)
+	s.ctr += lanes
+	s.off = 0
+}
+
+// Wipe erases the key stream state of s. It must be reinitialized before
+// further use.
+func (s *Stream) Wipe() { *s = Stream{} }
+
 // NOTE: Don't bother trying to optimize hChaCha; it contributes very little to
 // the total runtime of XORKeyStream. I tried swapping in an asm version and it
 // only shaved off about 30ns.
@@ -0,0 +1,159 @@
+// +build amd64,!gccgo,!appengine,!nacl
+
+#include "textflag.h"
+
+// Computes one ChaCha block for each of 8 lanes in lockstep. As in
+// hChaChaLanesAVX2, each YMM register holds one word of the state for all 8
+// lanes, so the keystream is transposed before it is stored, giving each lane
+// its own contiguous 64-byte block.
+
+DATA rol16<>+0x00(SB)/8, $0x0504070601000302
+DATA rol16<>+0x08(SB)/8, $0x0D0C0F0E09080B0A
+DATA rol16<>+0x10(SB)/8, $0x0504070601000302
+DATA rol16<>+0x18(SB)/8, $0x0D0C0F0E09080B0A
+GLOBL rol16<>(SB), (NOPTR+RODATA), $32
+
+DATA rol8<>+0x00(SB)/8, $0x0605040702010003
+DATA rol8<>+0x08(SB)/8, $0x0E0D0C0F0A09080B
+DATA rol8<>+0x10(SB)/8, $0x0605040702010003
+DATA rol8<>+0x18(SB)/8, $0x0E0D0C0F0A09080B
+GLOBL rol8<>(SB), (NOPTR+RODATA), $32
+
+#define ROTL(n, v, t) \
+	VPSLLD $n, v, t;        \
+	VPSRLD $(32-n), v, v;   \
+	VPOR   t, v, v
+
+// QUARTER_ROUND performs a ChaCha quarter round on a, b, c, d, using s as a
+// temporary register.
+#define QUARTER_ROUND(a, b, c, d, s) \
+	VMOVDQU s, 0(SP);            \
+	VPADDD  b, a, a;             \
+	VPXOR   a, d, d;             \
+	VPSHUFB rol16<>(SB), d, d;   \
+	VPADDD  d, c, c;             \
+	VPXOR   c, b, b;             \
+	ROTL(12, b, s);              \
+	VPADDD  b, a, a;             \
+	VPXOR   a, d, d;             \
+	VPSHUFB rol8<>(SB), d, d;    \
+	VPADDD  d, c, c;             \
+	VPXOR   c, b, b;             \
+	ROTL(7, b, s);               \
+	VMOVDQU 0(SP), s
+
+// TRANSPOSE_STORE transposes the 8x8 matrix of words in Y0-Y7, using Y8-Y15
+// as temporaries, and stores row i of the result at off+64*i(DI).
+#define TRANSPOSE_STORE(off) \
+	VPUNPCKLDQ Y1, Y0, Y8;            \
+	VPUNPCKHDQ Y1, Y0, Y9;            \
+	VPUNPCKLDQ Y3, Y2, Y10;           \
+	VPUNPCKHDQ Y3, Y2, Y11;           \
+	VPUNPCKLDQ Y5, Y4, Y12;           \
+	VPUNPCKHDQ Y5, Y4, Y13;           \
+	VPUNPCKLDQ Y7, Y6, Y14;           \
+	VPUNPCKHDQ Y7, Y6, Y15;           \
+	VPUNPCKLQDQ Y10, Y8, Y0;          \
+	VPUNPCKHQDQ Y10, Y8, Y1;          \
+	VPUNPCKLQDQ Y11, Y9, Y2;          \
+	VPUNPCKHQDQ Y11, Y9, Y3;          \
+	VPUNPCKLQDQ Y14, Y12, Y4;         \
+	VPUNPCKHQDQ Y14, Y12, Y5;         \
+	VPUNPCKLQDQ Y15, Y13, Y6;         \
+	VPUNPCKHQDQ Y15, Y13, Y7;         \
+	VPERM2I128  $0x20, Y4, Y0, Y8;    \
+	VPERM2I128  $0x20, Y5, Y1, Y9;    \
+	VPERM2I128  $0x20, Y6, Y2, Y10;   \
+	VPERM2I128  $0x20, Y7, Y3, Y11;   \
+	VPERM2I128  $0x31, Y4, Y0, Y12;   \
+	VPERM2I128  $0x31, Y5, Y1, Y13;   \
+	VPERM2I128  $0x31, Y6, Y2, Y14;   \
+	VPERM2I128  $0x31, Y7, Y3, Y15;   \
+	VMOVDQU     Y8, (off+0*64)(DI);   \
+	VMOVDQU     Y9, (off+1*64)(DI);   \
+	VMOVDQU     Y10, (off+2*64)(DI);  \
+	VMOVDQU     Y11, (off+3*64)(DI);  \
+	VMOVDQU     Y12, (off+4*64)(DI);  \
+	VMOVDQU     Y13, (off+5*64)(DI);  \
+	VMOVDQU     Y14, (off+6*64)(DI);  \
+	VMOVDQU     Y15, (off+7*64)(DI)
+
+// func chachaLanesAVX2(out *[lanes][64]byte, state *[16][lanes]uint32, rounds int)
+TEXT ·chachaLanesAVX2(SB), NOSPLIT, $288-24
+	MOVQ out+0(FP), DI
+	MOVQ state+8(FP), SI
+	MOVQ rounds+16(FP), CX
+
+	VMOVDQU 0(SI), Y0
+	VMOVDQU 32(SI), Y1
+	VMOVDQU 64(SI), Y2
+	VMOVDQU 96(SI), Y3
+	VMOVDQU 128(SI), Y4
+	VMOVDQU 160(SI), Y5
+	VMOVDQU 192(SI), Y6
+	VMOVDQU 224(SI), Y7
+	VMOVDQU 256(SI), Y8
+	VMOVDQU 288(SI), Y9
+	VMOVDQU 320(SI), Y10
+	VMOVDQU 352(SI), Y11
+	VMOVDQU 384(SI), Y12
+	VMOVDQU 416(SI), Y13
+	VMOVDQU 448(SI), Y14
+	VMOVDQU 480(SI), Y15
+
+DOUBLE_ROUND:
+	// columns
+	QUARTER_ROUND(Y0, Y4, Y8, Y12, Y1)
+	QUARTER_ROUND(Y1, Y5, Y9, Y13, Y0)
+	QUARTER_ROUND(Y2, Y6, Y10, Y14, Y0)
+	QUARTER_ROUND(Y3, Y7, Y11, Y15, Y0)
+
+	// diagonals
+	QUARTER_ROUND(Y0, Y5, Y10, Y15, Y1)
+	QUARTER_ROUND(Y1, Y6, Y11, Y12, Y0)
+	QUARTER_ROUND(Y2, Y7, Y8, Y13, Y0)
+	QUARTER_ROUND(Y3, Y4, Y9, Y14, Y0)
+
+	SUBQ $2, CX
+	JA   DOUBLE_ROUND
+
+	// add the input state, and set aside words 8-15 while words 0-7 are
+	// transposed
+	VPADDD 256(SI), Y8, Y8
+	VPADDD 288(SI), Y9, Y9
+	VPADDD 320(SI), Y10, Y10
+	VPADDD 352(SI), Y11, Y11
+	VPADDD 384(SI), Y12, Y12
+	VPADDD 416(SI), Y13, Y13
+	VPADDD 448(SI), Y14, Y14
+	VPADDD 480(SI), Y15, Y15
+	VMOVDQU Y8, 32(SP)
+	VMOVDQU Y9, 64(SP)
+	VMOVDQU Y10, 96(SP)
+	VMOVDQU Y11, 128(SP)
+	VMOVDQU Y12, 160(SP)
+	VMOVDQU Y13, 192(SP)
+	VMOVDQU Y14, 224(SP)
+	VMOVDQU Y15, 256(SP)
+	VPADDD 0(SI), Y0, Y0
+	VPADDD 32(SI), Y1, Y1
+	VPADDD 64(SI), Y2, Y2
+	VPADDD 96(SI), Y3, Y3
+	VPADDD 128(SI), Y4, Y4
+	VPADDD 160(SI), Y5, Y5
+	VPADDD 192(SI), Y6, Y6
+	VPADDD 224(SI), Y7, Y7
+	TRANSPOSE_STORE(0)
+
+	VMOVDQU 32(SP), Y0
+	VMOVDQU 64(SP), Y1
+	VMOVDQU 96(SP), Y2
+	VMOVDQU 128(SP), Y3
+	VMOVDQU 160(SP), Y4
+	VMOVDQU 192(SP), Y5
+	VMOVDQU 224(SP), Y6
+	VMOVDQU 256(SP), Y7
+	TRANSPOSE_STORE(32)
+
+	VZEROUPPER
+	RET
@@ -1,10 +1,147 @@
 package xchacha
 
 import (
+	"bytes"
 	"crypto/rand"
 	"testing"
 )
 
+func TestHChaChaLanes(t *testing.T) {
+	for _, rounds := range []int{8, 12, 20} {
+		var key [8]uint32
+		var nonces [4][lanes]uint32
+		for i := range key {
+			key[i] = uint32(i) * 0x9e3779b9
+		}
+		for w := range nonces {
+			for i := range nonces[w] {
+				nonces[w][i] = uint32(w*lanes+i) * 0x85ebca6b
+			}
+		}
+		var exp, got [8][lanes]uint32
+		hChaChaLanesGeneric(&exp, &nonces, &key, rounds)
+		hChaChaLanes(&got, &nonces, &key, rounds)
+		if got != exp {
+			t.Fatalf("HChaCha%v: lanes do not match generic implementation", rounds)
+		}
+	}
+}
+
+func TestChaChaLanes(t *testing.T) {
+	for _, rounds := range []int{8, 12, 20} {
+		var state [16][lanes]uint32
+		for w := range state {
+			for i := range state[w] {
+				state[w][i] = uint32(w*lanes+i) * 0x9e3779b9
+			}
+		}
+		var exp, got [lanes][64]byte
+		chachaLanesGeneric(&exp, &state, rounds)
+		chachaLanes(&got, &state, rounds)
+		if got != exp {
+			t.Fatalf("ChaCha%v: lanes do not match generic implementation", rounds)
+		}
+	}
+}
+
+func TestXORKeyStreams(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	lengths := []func(i int) int{
+		func(i int) int { return 16*i + 1 },
+		func(i int) int { return 496 },
+		func(i int) int { return 67 * i },
+		func(i int) int { return 4096 - 16 },
+	}
+	for _, rounds := range []int{8, 12, 20} {
+		for _, n := range []int{1, 7, 8, 19} {
+			for _, length := range lengths {
+				testXORKeyStreams(t, key, rounds, n, length)
+			}
+		}
+	}
+}
+
+func testXORKeyStreams(t *testing.T, key []byte, rounds, n int, length func(int) int) {
+	t.Helper()
+	var msgs, nonces, exp [][]byte
+	for i := 0; i < n; i++ {
+		msg := make([]byte, length(i))
+		rand.Read(msg)
+		nonce := make([]byte, NonceSize)
+		rand.Read(nonce)
+		e := make([]byte, len(msg))
+		XORKeyStream(e, msg, nonce, key, rounds)
+		msgs = append(msgs, msg)
+		nonces = append(nonces, nonce)
+		exp = append(exp, e)
+	}
+	XORKeyStreams(msgs, nonces, key, rounds)
+	for i := range msgs {
+		if !bytes.Equal(msgs[i], exp[i]) {
+			t.Fatalf("XChaCha%v: %v-byte message %v of %v does not match XORKeyStream", rounds, len(msgs[i]), i, n)
+		}
+	}
+}
+
+func TestNewCipher(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	nonce := make([]byte, NonceSize)
+	rand.Read(nonce)
+	// test the one-block refill used without vectorized lanes, too
+	defer func(h bool) { HasLanes = h }(HasLanes)
+	for _, hasLanes := range []bool{true, false} {
+		HasLanes = hasLanes
+		for _, rounds := range []int{8, 12, 20} {
+			exp := make([]byte, 1136)
+			XORKeyStream(exp, exp, nonce, key, rounds)
+			// generate the same stream in uneven pieces
+			got := make([]byte, len(exp))
+			c := NewCipher(nonce, key, rounds)
+			var off int
+			for _, n := range []int{48, 1, 63, 64, 100, 860} {
+				c.XORKeyStream(got[off:][:n], got[off:][:n])
+				off += n
+			}
+			if !bytes.Equal(got, exp) {
+				t.Fatalf("XChaCha%v (HasLanes=%v): NewCipher does not match XORKeyStream", rounds, hasLanes)
+			}
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	key := make([]byte, KeySize)
+	nonce := make([]byte, NonceSize)
+	var s Stream
+	buf := make([]byte, 600)
+	for i := 0; i < 3; i++ {
+		// reinitialize mid-block, with a different key and number of rounds
+		rand.Read(key)
+		rand.Read(nonce)
+		rounds := []int{8, 12, 20}[i]
+		s.Init(nonce, key, rounds)
+		exp := make([]byte, len(buf))
+		XORKeyStream(exp, exp, nonce, key, rounds)
+		for j := range buf {
+			buf[j] = 0
+		}
+		s.XORKeyStream(buf, buf)
+		if !bytes.Equal(buf, exp) {
+			t.Fatalf("XChaCha%v: reinitialized Stream does not match XORKeyStream", rounds)
+		}
+		s.XORKeyStream(buf[:7], buf[:7])
+	}
+	if n := testing.AllocsPerRun(10, func() { s.Init(nonce, key, 12); s.XORKeyStream(buf, buf) }); n > 0 {
+		t.Errorf("Init and XORKeyStream allocated %v times", n)
+	}
+	s.Wipe()
+	if s != (Stream{}) {
+		t.Error("Wipe did not erase the Stream")
+	}
+}
+
 func BenchmarkXChaCha(b *testing.B) {
 	key := make([]byte, 32)
 	rand.Read(key)
@@ -47,3 +184,29 @@ func BenchmarkHChaCha(b *testing.B) {
 	b.Run("HChaCha12", withRounds(12))
 	b.Run("HChaCha20", withRounds(20))
 }
+
+func BenchmarkXORKeyStreams(b *testing.B) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	msgs := make([][]byte, lanes)
+	nonces := make([][]byte, lanes)
+	for i := range msgs {
+		msgs[i] = make([]byte, 512)
+		nonces[i] = make([]byte, NonceSize)
+		rand.Read(nonces[i])
+	}
+	b.Run("Serial", func(b *testing.B) {
+		b.SetBytes(int64(lanes * 512))
+		for i := 0; i < b.N; i++ {
+			for j := range msgs {
+				XORKeyStream(msgs[j], msgs[j], nonces[j], key, 12)
+			}
+		}
+	})
+	b.Run("Lanes", func(b *testing.B) {
+		b.SetBytes(int64(lanes * 512))
+		for i := 0; i < b.N; i++ {
+			XORKeyStreams(msgs, nonces, key, 12)
+		}
+	})
+}
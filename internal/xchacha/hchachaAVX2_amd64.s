@@ -0,0 +1,101 @@
+// +build amd64,!gccgo,!appengine,!nacl
+
+#include "textflag.h"
+
+// Computes HChaCha for 8 nonces in lockstep. Each YMM register holds one word
+// of the ChaCha state for all 8 lanes. All 16 registers are needed for the
+// state, so the 12- and 7-bit rotations borrow a register not involved in the
+// current quarter round, spilling it to the stack.
+
+DATA rol16<>+0x00(SB)/8, $0x0504070601000302
+DATA rol16<>+0x08(SB)/8, $0x0D0C0F0E09080B0A
+DATA rol16<>+0x10(SB)/8, $0x0504070601000302
+DATA rol16<>+0x18(SB)/8, $0x0D0C0F0E09080B0A
+GLOBL rol16<>(SB), (NOPTR+RODATA), $32
+
+DATA rol8<>+0x00(SB)/8, $0x0605040702010003
+DATA rol8<>+0x08(SB)/8, $0x0E0D0C0F0A09080B
+DATA rol8<>+0x10(SB)/8, $0x0605040702010003
+DATA rol8<>+0x18(SB)/8, $0x0E0D0C0F0A09080B
+GLOBL rol8<>(SB), (NOPTR+RODATA), $32
+
+DATA sigma<>+0x00(SB)/4, $0x61707865
+DATA sigma<>+0x04(SB)/4, $0x3320646e
+DATA sigma<>+0x08(SB)/4, $0x79622d32
+DATA sigma<>+0x0c(SB)/4, $0x6b206574
+GLOBL sigma<>(SB), (NOPTR+RODATA), $16
+
+#define ROTL(n, v, t) \
+	VPSLLD $n, v, t;        \
+	VPSRLD $(32-n), v, v;   \
+	VPOR   t, v, v
+
+// QUARTER_ROUND performs a ChaCha quarter round on a, b, c, d, using s as a
+// temporary register.
+#define QUARTER_ROUND(a, b, c, d, s) \
+	VMOVDQU s, 0(SP);            \
+	VPADDD  b, a, a;             \
+	VPXOR   a, d, d;             \
+	VPSHUFB rol16<>(SB), d, d;   \
+	VPADDD  d, c, c;             \
+	VPXOR   c, b, b;             \
+	ROTL(12, b, s);              \
+	VPADDD  b, a, a;             \
+	VPXOR   a, d, d;             \
+	VPSHUFB rol8<>(SB), d, d;    \
+	VPADDD  d, c, c;             \
+	VPXOR   c, b, b;             \
+	ROTL(7, b, s);               \
+	VMOVDQU 0(SP), s
+
+// func hChaChaLanesAVX2(out *[8][lanes]uint32, nonces *[4][lanes]uint32, key *[8]uint32, rounds int)
+TEXT ·hChaChaLanesAVX2(SB), NOSPLIT, $32-32
+	MOVQ out+0(FP), DI
+	MOVQ nonces+8(FP), SI
+	MOVQ key+16(FP), DX
+	MOVQ rounds+24(FP), CX
+
+	VPBROADCASTD sigma<>+0x00(SB), Y0
+	VPBROADCASTD sigma<>+0x04(SB), Y1
+	VPBROADCASTD sigma<>+0x08(SB), Y2
+	VPBROADCASTD sigma<>+0x0c(SB), Y3
+	VPBROADCASTD 0(DX), Y4
+	VPBROADCASTD 4(DX), Y5
+	VPBROADCASTD 8(DX), Y6
+	VPBROADCASTD 12(DX), Y7
+	VPBROADCASTD 16(DX), Y8
+	VPBROADCASTD 20(DX), Y9
+	VPBROADCASTD 24(DX), Y10
+	VPBROADCASTD 28(DX), Y11
+	VMOVDQU 0(SI), Y12
+	VMOVDQU 32(SI), Y13
+	VMOVDQU 64(SI), Y14
+	VMOVDQU 96(SI), Y15
+
+DOUBLE_ROUND:
+	// columns
+	QUARTER_ROUND(Y0, Y4, Y8, Y12, Y1)
+	QUARTER_ROUND(Y1, Y5, Y9, Y13, Y0)
+	QUARTER_ROUND(Y2, Y6, Y10, Y14, Y0)
+	QUARTER_ROUND(Y3, Y7, Y11, Y15, Y0)
+
+	// diagonals
+	QUARTER_ROUND(Y0, Y5, Y10, Y15, Y1)
+	QUARTER_ROUND(Y1, Y6, Y11, Y12, Y0)
+	QUARTER_ROUND(Y2, Y7, Y8, Y13, Y0)
+	QUARTER_ROUND(Y3, Y4, Y9, Y14, Y0)
+
+	SUBQ $2, CX
+	JA   DOUBLE_ROUND
+
+	VMOVDQU Y0, 0(DI)
+	VMOVDQU Y1, 32(DI)
+	VMOVDQU Y2, 64(DI)
+	VMOVDQU Y3, 96(DI)
+	VMOVDQU Y12, 128(DI)
+	VMOVDQU Y13, 160(DI)
+	VMOVDQU Y14, 192(DI)
+	VMOVDQU Y15, 224(DI)
+
+	VZEROUPPER
+	RET
@@ -0,0 +1,149 @@
+package xchacha
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// lanes is the number of messages processed in lockstep by XORKeyStreams.
+const lanes = 8
+
+// XORKeyStreams xors each msgs[i] with the key stream derived from the key and
+// nonces[i], in place. It is equivalent to calling XORKeyStream on each message,
+// but processes up to 8 messages in lockstep: first their HChaCha subkeys, and
+// then their ChaCha key streams, one 64-byte block of each message at a time.
+// This amortizes the per-message overhead of short messages, and is fastest
+// when the messages are the same length.
+func XORKeyStreams(msgs, nonces [][]byte, key []byte, rounds int) {
+	if len(msgs) != len(nonces) {
+		panic("xchacha: number of messages and nonces must match")
+	}
+	var k [8]uint32
+	for i := range k {
+		k[i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	for len(msgs) > 0 {
+		n := len(msgs)
+		if n > lanes {
+			n = lanes
+		}
+		var hNonces [4][lanes]uint32
+		for i := 0; i < n; i++ {
+			for w := range hNonces {
+				hNonces[w][i] = binary.LittleEndian.Uint32(nonces[i][w*4:])
+			}
+		}
+		var subkeys [8][lanes]uint32
+		hChaChaLanes(&subkeys, &hNonces, &k, rounds)
+
+		// The ChaCha state of each lane is sigma, the subkey, a 64-bit block
+		// counter, and the last 8 bytes of the nonce.
+		var state [16][lanes]uint32
+		for w := range sigma {
+			for i := range state[w] {
+				state[w][i] = sigma[w]
+			}
+		}
+		copy(state[4:12], subkeys[:])
+		maxLen := 0
+		for i := 0; i < n; i++ {
+			state[14][i] = binary.LittleEndian.Uint32(nonces[i][16:])
+			state[15][i] = binary.LittleEndian.Uint32(nonces[i][20:])
+			if len(msgs[i]) > maxLen {
+				maxLen = len(msgs[i])
+			}
+		}
+		var stream [lanes][64]byte
+		for off := 0; off < maxLen; off += 64 {
+			ctr := uint64(off / 64)
+			for i := range state[12] {
+				state[12][i], state[13][i] = uint32(ctr), uint32(ctr>>32)
+			}
+			chachaLanes(&stream, &state, rounds)
+			for i := 0; i < n; i++ {
+				if off < len(msgs[i]) {
+					xorBlock(msgs[i][off:], &stream[i])
+				}
+			}
+		}
+		msgs, nonces = msgs[n:], nonces[n:]
+	}
+}
+
+// xorBlock xors the first 64 bytes of msg, or all of msg if it is shorter,
+// with block.
+func xorBlock(msg []byte, block *[64]byte) {
+	if len(msg) >= 64 {
+		for i := 0; i < 64; i += 8 {
+			x := binary.LittleEndian.Uint64(msg[i:]) ^ binary.LittleEndian.Uint64(block[i:])
+			binary.LittleEndian.PutUint64(msg[i:], x)
+		}
+		return
+	}
+	for i := range msg {
+		msg[i] ^= block[i]
+	}
+}
+
+// hChaChaLanesGeneric computes HChaCha for each lane of nonces, using the same
+// key for every lane. Words are stored in column-major order, i.e. nonces[w][i]
+// is word w of the nonce for lane i.
+func hChaChaLanesGeneric(out *[8][lanes]uint32, nonces *[4][lanes]uint32, key *[8]uint32, rounds int) {
+	var k [32]byte
+	for w := range key {
+		binary.LittleEndian.PutUint32(k[w*4:], key[w])
+	}
+	for i := 0; i < lanes; i++ {
+		var nonce [16]byte
+		for w := range nonces {
+			binary.LittleEndian.PutUint32(nonce[w*4:], nonces[w][i])
+		}
+		var sub [32]byte
+		hChaCha(&sub, &nonce, &k, rounds)
+		for w := range out {
+			out[w][i] = binary.LittleEndian.Uint32(sub[w*4:])
+		}
+	}
+}
+
+// chachaLanesGeneric computes one ChaCha block for each lane of state, which is
+// stored in column-major order like the arguments of hChaChaLanesGeneric. The
+// block for lane i is written to out[i].
+func chachaLanesGeneric(out *[lanes][64]byte, state *[16][lanes]uint32, rounds int) {
+	for i := 0; i < lanes; i++ {
+		var in [16]uint32
+		for w := range in {
+			in[w] = state[w][i]
+		}
+		chachaBlock(&out[i], &in, rounds)
+	}
+}
+
+// chachaBlock computes the ChaCha block of state.
+func chachaBlock(out *[64]byte, state *[16]uint32, rounds int) {
+	x := *state
+	for r := 0; r < rounds; r += 2 {
+		quarterRound(&x, 0, 4, 8, 12)
+		quarterRound(&x, 1, 5, 9, 13)
+		quarterRound(&x, 2, 6, 10, 14)
+		quarterRound(&x, 3, 7, 11, 15)
+		quarterRound(&x, 0, 5, 10, 15)
+		quarterRound(&x, 1, 6, 11, 12)
+		quarterRound(&x, 2, 7, 8, 13)
+		quarterRound(&x, 3, 4, 9, 14)
+	}
+	for w := range x {
+		binary.LittleEndian.PutUint32(out[w*4:], x[w]+state[w])
+	}
+}
+
+func quarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
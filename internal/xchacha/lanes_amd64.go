@@ -0,0 +1,33 @@
+//go:build amd64
+// +build amd64
+
+package xchacha
+
+import "golang.org/x/sys/cpu"
+
+// HasLanes reports whether XORKeyStreams processes its lanes with vector
+// instructions. Without them, it is slower than calling XORKeyStream on each
+// message.
+var HasLanes = cpu.X86.HasAVX2
+
+//go:noescape
+func hChaChaLanesAVX2(out *[8][lanes]uint32, nonces *[4][lanes]uint32, key *[8]uint32, rounds int)
+
+//go:noescape
+func chachaLanesAVX2(out *[lanes][64]byte, state *[16][lanes]uint32, rounds int)
+
+func hChaChaLanes(out *[8][lanes]uint32, nonces *[4][lanes]uint32, key *[8]uint32, rounds int) {
+	if cpu.X86.HasAVX2 {
+		hChaChaLanesAVX2(out, nonces, key, rounds)
+	} else {
+		hChaChaLanesGeneric(out, nonces, key, rounds)
+	}
+}
+
+func chachaLanes(out *[lanes][64]byte, state *[16][lanes]uint32, rounds int) {
+	if cpu.X86.HasAVX2 {
+		chachaLanesAVX2(out, state, rounds)
+	} else {
+		chachaLanesGeneric(out, state, rounds)
+	}
+}
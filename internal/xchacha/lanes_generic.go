@@ -0,0 +1,17 @@
+//go:build !amd64
+// +build !amd64
+
+package xchacha
+
+// HasLanes reports whether XORKeyStreams processes its lanes with vector
+// instructions. Without them, it is slower than calling XORKeyStream on each
+// message.
+var HasLanes = false
+
+func hChaChaLanes(out *[8][lanes]uint32, nonces *[4][lanes]uint32, key *[8]uint32, rounds int) {
+	hChaChaLanesGeneric(out, nonces, key, rounds)
+}
+
+func chachaLanes(out *[lanes][64]byte, state *[16][lanes]uint32, rounds int) {
+	chachaLanesGeneric(out, state, rounds)
+}
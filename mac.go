@@ -0,0 +1,72 @@
+package adiantum
+
+import (
+	"crypto/subtle"
+
+	"lukechampine.com/adiantum/internal/xchacha"
+	"lukechampine.com/adiantum/nh"
+)
+
+const (
+	// MACSize is the size of a MAC tag.
+	MACSize = 16
+
+	// MACNonceSize is the size of a MAC nonce.
+	MACNonceSize = xchacha.NonceSize
+)
+
+// A MAC is a nonce-based message authentication code built from the same
+// primitives as Adiantum: the tag of a message is its NHPoly1305 hash (the
+// Adiantum hash with an empty tweak), plus a one-time mask derived from the
+// nonce with XChaCha12, modulo 2^128. This is a Wegman–Carter MAC, so it runs at
+// the speed of NH, but a nonce must never be used for two different messages
+// with the same key.
+//
+// The hash keys are taken from the key stream of the zero nonce, starting at
+// its second block, and each mask from the first block of the key stream of its
+// nonce, so no key stream byte is used twice, whatever nonces are chosen. The
+// key should not also be used as an Adiantum key.
+//
+// A MAC is safe for concurrent use.
+type MAC struct {
+	key  [32]byte
+	hash hashNHPoly1305
+}
+
+// NewMAC returns a MAC with the specified key. The key must be 32 bytes.
+func NewMAC(key []byte) *MAC {
+	if len(key) != xchacha.KeySize {
+		panic("adiantum: key must be 32 bytes long")
+	}
+	m := new(MAC)
+	copy(m.key[:], key)
+	var zero [MACNonceSize]byte
+	keyBuf := make([]byte, 64+16+16+nh.KeySize)
+	xchacha.XORKeyStream(keyBuf, keyBuf, zero[:], m.key[:], 12)
+	keyBuf = keyBuf[64:]
+	var keyT, keyM [32]byte
+	copy(keyT[:16], keyBuf[:16])
+	copy(keyM[:16], keyBuf[16:32])
+	m.hash.keyT.Init(&keyT)
+	m.hash.keyM.Init(&keyM)
+	copy(m.hash.keyNH[:], keyBuf[32:])
+	return m
+}
+
+// Sum returns the tag of msg under the specified nonce, which must be
+// MACNonceSize bytes.
+func (m *MAC) Sum(nonce, msg []byte) [MACSize]byte {
+	if len(nonce) != MACNonceSize {
+		panic("adiantum: nonce must be 24 bytes long")
+	}
+	var mask [MACSize]byte
+	xchacha.XORKeyStream(mask[:], mask[:], nonce, m.key[:], 12)
+	return addHashes(addHashes(m.hash.sumT(len(msg), nil), m.hash.sumM(msg)), mask)
+}
+
+// Verify reports whether tag is the tag of msg under the specified nonce,
+// which must be MACNonceSize bytes. The comparison takes constant time.
+func (m *MAC) Verify(nonce, msg, tag []byte) bool {
+	sum := m.Sum(nonce, msg)
+	return subtle.ConstantTimeCompare(sum[:], tag) == 1
+}
@@ -0,0 +1,99 @@
+package adiantum
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"lukechampine.com/adiantum/reference"
+)
+
+func TestMAC(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	m := NewMAC(key)
+
+	// compare against a tag computed with the reference primitives
+	keys := reference.XChaCha(12, key, make([]byte, MACNonceSize), 64+16+16+1072)[64:]
+	for _, n := range []int{0, 1, 16, 17, 1024, 1025, 5000} {
+		msg := make([]byte, n)
+		rand.Read(msg)
+		nonce := make([]byte, MACNonceSize)
+		rand.Read(nonce)
+		var hash, mask [16]byte
+		copy(hash[:], reference.AdiantumHash(keys[:16], keys[16:32], keys[32:], nil, msg))
+		copy(mask[:], reference.XChaCha(12, key, nonce, 16))
+		exp := addHashes(hash, mask)
+		if tag := m.Sum(nonce, msg); tag != exp {
+			t.Fatalf("Sum of %v-byte message does not match reference:\nexp: %x\ngot: %x", n, exp, tag)
+		}
+		if !m.Verify(nonce, msg, exp[:]) {
+			t.Fatalf("Verify rejected valid tag of %v-byte message", n)
+		}
+	}
+}
+
+func TestMACVerify(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	m := NewMAC(key)
+	nonce := make([]byte, MACNonceSize)
+	rand.Read(nonce)
+	msg := []byte("hello, world")
+	tag := m.Sum(nonce, msg)
+
+	otherNonce := append([]byte(nil), nonce...)
+	otherNonce[0] ^= 1
+	badTag := tag
+	badTag[15] ^= 0x80
+	tests := []struct {
+		desc  string
+		nonce []byte
+		msg   []byte
+		tag   []byte
+	}{
+		{"modified message", nonce, []byte("hello, World"), tag[:]},
+		{"zero-extended message", nonce, append(msg, 0), tag[:]},
+		{"truncated message", nonce, msg[:len(msg)-1], tag[:]},
+		{"different nonce", otherNonce, msg, tag[:]},
+		{"modified tag", nonce, msg, badTag[:]},
+		{"truncated tag", nonce, msg, tag[:15]},
+		{"other key", nonce, msg, nil},
+	}
+	for _, test := range tests {
+		if test.tag == nil {
+			rand.Read(key)
+			ot := NewMAC(key).Sum(nonce, msg)
+			test.tag = ot[:]
+		}
+		if m.Verify(test.nonce, test.msg, test.tag) {
+			t.Errorf("%v: Verify accepted invalid tag", test.desc)
+		}
+	}
+	if !m.Verify(nonce, msg, tag[:]) {
+		t.Error("Verify rejected valid tag")
+	}
+	if bytes.Equal(tag[:], make([]byte, MACSize)) {
+		t.Error("tag is zero")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic for short nonce")
+			}
+		}()
+		m.Sum(nonce[:12], msg)
+	}()
+}
+
+func BenchmarkMAC(b *testing.B) {
+	m := NewMAC(make([]byte, 32))
+	nonce := make([]byte, MACNonceSize)
+	msg := make([]byte, 4096)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.Sum(nonce, msg)
+	}
+}
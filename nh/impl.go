@@ -0,0 +1,25 @@
+package nh
+
+import "os"
+
+// An impl is an implementation of NH.
+type impl struct {
+	name string
+	sum  func(out *[32]byte, m []byte, key []byte)
+}
+
+// sumImpl is the implementation used by Sum. By default it is the fastest
+// implementation the CPU supports, but the ADIANTUM_NH_IMPL environment
+// variable can select any other implementation in impls by name, so that the
+// slower paths can be exercised on any machine; unknown or unsupported names
+// are ignored.
+var sumImpl = selectImpl(os.Getenv("ADIANTUM_NH_IMPL"))
+
+func selectImpl(name string) impl {
+	for _, im := range impls {
+		if im.name == name {
+			return im
+		}
+	}
+	return impls[0]
+}
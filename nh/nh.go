@@ -1,5 +1,35 @@
+// Package nh implements NH, the ε-almost-∆-universal hash function used by
+// Adiantum to compress long messages before hashing them with Poly1305.
+//
+// This is Adiantum's NH, which is not the NH of UMAC (RFC 4418). Adiantum's NH
+// multiplies words two apart within each 16-byte block and computes four sums
+// with key offsets of 16 bytes; UMAC's multiplies words four apart within each
+// 32-byte block, and its L1 hash also differs in padding and in adding the
+// message length. The two produce different outputs for every key; the umac
+// package has its own implementation of UMAC's NH.
 package nh // import "lukechampine.com/adiantum/nh"
 
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// ChunkSize is the number of message bytes covered by each hash output by
+	// Hash.
+	ChunkSize = 1024
+
+	// HashSize is the size of an NH hash.
+	HashSize = 32
+
+	// KeySize is the size of a key sufficient to Hash a message of any length.
+	KeySize = ChunkSize + 48
+)
+
+// ErrKeyTooShort is returned by Hash when the key is too short for the message;
+// see KeyLen.
+var ErrKeyTooShort = errors.New("nh: key is too short for message")
+
 // Sum computes the NH hash of m with the specified key and places the result in
 // out. The key must be at least 48 bytes larger than the message.
 func Sum(out *[32]byte, m []byte, key []byte) {
@@ -10,3 +40,51 @@ func Sum(out *[32]byte, m []byte, key []byte) {
 	}
 	sum(out, m, key)
 }
+
+// KeyLen returns the number of key bytes that Hash uses to hash an n-byte
+// message. It is never more than KeySize.
+func KeyLen(n int) int {
+	if n == 0 {
+		return 0
+	} else if n > ChunkSize {
+		n = ChunkSize
+	}
+	return (n+15)&^15 + 48
+}
+
+// Hash splits m into chunks of ChunkSize bytes, computes the NH hash of each
+// chunk with the specified key, and appends the hashes to dst, in order. The
+// final chunk is zero-padded to a multiple of 16 bytes, and an empty message
+// produces no hashes. This is how Adiantum hashes messages of any length.
+//
+// The key must be at least KeyLen(len(m)) bytes long; any additional bytes are
+// ignored.
+func Hash(dst, m, key []byte) ([]byte, error) {
+	if len(key) < KeyLen(len(m)) {
+		return dst, ErrKeyTooShort
+	}
+	var out [32]byte
+	for len(m) >= ChunkSize {
+		sum(&out, m[:ChunkSize], key)
+		dst = append(dst, out[:]...)
+		m = m[ChunkSize:]
+	}
+	if len(m) > 0 {
+		// NH sums the contributions of each 16-byte block, so a partial final
+		// block can be padded and hashed separately
+		full := len(m) &^ 15
+		sum(&out, m[:full], key)
+		if full < len(m) {
+			var block [16]byte
+			copy(block[:], m[full:])
+			var tail [32]byte
+			sum(&tail, block[:], key[full:])
+			for i := 0; i < len(out); i += 8 {
+				s := binary.LittleEndian.Uint64(out[i:]) + binary.LittleEndian.Uint64(tail[i:])
+				binary.LittleEndian.PutUint64(out[i:], s)
+			}
+		}
+		dst = append(dst, out[:]...)
+	}
+	return dst, nil
+}
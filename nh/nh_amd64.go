@@ -1,3 +1,4 @@
+//go:build amd64
 // +build amd64
 
 package nh
@@ -13,13 +14,29 @@ func sumAVX2(out *[32]byte, m []byte, key []byte)
 //go:noescape
 func sumSSE2(out *[32]byte, m []byte, key []byte)
 
+// impls lists the implementations supported by the CPU, fastest first.
+var impls = func() []impl {
+	var is []impl
+	if cpu.X86.HasAVX2 {
+		is = append(is, impl{"avx2", sumAVX2})
+	}
+	if cpu.X86.HasSSE2 {
+		is = append(is, impl{"sse2", sumSSE2})
+	}
+	return append(is, impl{"asm", sumAsm}, impl{"generic", sumGeneric})
+}()
+
 func sum(out *[32]byte, m []byte, key []byte) {
-	switch {
-	case cpu.X86.HasAVX2:
+	// call the implementations directly, rather than through sumImpl.sum, so
+	// that out does not escape
+	switch sumImpl.name {
+	case "avx2":
 		sumAVX2(out, m, key)
-	case cpu.X86.HasSSE2:
+	case "sse2":
 		sumSSE2(out, m, key)
-	default:
+	case "asm":
 		sumAsm(out, m, key)
+	default:
+		sumGeneric(out, m, key)
 	}
 }
@@ -30,6 +30,10 @@ TEXT ·sumAsm(SB), 4, $0-56
 	XORQ Sum2, Sum2
 	XORQ Sum3, Sum3
 
+	// an empty message hashes to 0
+	TESTQ N, N
+	JZ    NH_DONE
+
 NH_LOOP:
 	// process next 16 bytes
 	ROUND( 0,  0, 2,  2, Sum0)
@@ -47,6 +51,7 @@ NH_LOOP:
 	SUBQ $16, N
 	JNZ  NH_LOOP
 
+NH_DONE:
 	// copy sums to out
 	MOVQ Sum0, 0*8(Dst)
 	MOVQ Sum1, 1*8(Dst)
@@ -1,10 +1,9 @@
-// +build !amd64
-
 package nh
 
 import "encoding/binary"
 
-func sum(out *[32]byte, m []byte, key []byte) {
+// sumGeneric is the portable implementation of NH.
+func sumGeneric(out *[32]byte, m []byte, key []byte) {
 	var k [16]uint32
 	for i := 4; i < 16; i++ {
 		k[i] = binary.LittleEndian.Uint32(key[:4])
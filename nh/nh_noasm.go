@@ -0,0 +1,11 @@
+//go:build !amd64
+// +build !amd64
+
+package nh
+
+// impls lists the implementations supported by the CPU, fastest first.
+var impls = []impl{{"generic", sumGeneric}}
+
+func sum(out *[32]byte, m []byte, key []byte) {
+	sumGeneric(out, m, key)
+}
@@ -1,51 +1,136 @@
 package nh
 
 import (
+	"bytes"
 	"crypto/rand"
-	"encoding/hex"
-	"encoding/json"
-	"io/ioutil"
+	"os"
 	"testing"
-)
 
-func fromHex(s string) []byte {
-	b, _ := hex.DecodeString(s)
-	return b
-}
+	"lukechampine.com/adiantum/internal/kat"
+)
 
 type testVector struct {
 	Description string `json:"description"`
 	Input       struct {
-		Key     string `json:"key_hex"`
-		Message string `json:"message_hex"`
+		Key     kat.HexBytes `json:"key_hex"`
+		Message kat.HexBytes `json:"message_hex"`
 	} `json:"input"`
-	Hash string `json:"hash_hex"`
+	Hash kat.HexBytes `json:"hash_hex"`
 }
 
-func readTestVectors(t *testing.T, filename string) []testVector {
-	t.Helper()
-	js, err := ioutil.ReadFile(filename)
-	if err != nil {
-		t.Fatal(err)
-	}
+func TestNH(t *testing.T) {
+	// Only 20 test vectors are included. To test the full set, replace this
+	// file with the corresponding file from github.com/google/adiantum.
 	var tests []testVector
-	if err := json.Unmarshal(js, &tests); err != nil {
+	if err := kat.ReadFile("testdata/NH.json", &tests); err != nil {
 		t.Fatal(err)
 	}
-	return tests
+	for _, im := range impls {
+		for i, test := range tests {
+			var out [32]byte
+			im.sum(&out, test.Input.Message, test.Input.Key)
+			if !bytes.Equal(out[:], test.Hash) {
+				t.Fatalf("%v: %v (%v): Encryption failed:\nexp: %x\ngot: %x", im.name, test.Description, i, test.Hash, out[:])
+			}
+		}
+	}
 }
 
-func TestNH(t *testing.T) {
-	// Only 20 test vectors are included. To test the full set, replace this
-	// file with the corresponding file from github.com/google/adiantum.
-	tests := readTestVectors(t, "testdata/NH.json")
-	for i, test := range tests {
+func TestImpls(t *testing.T) {
+	// every implementation must agree with the generic one
+	key := make([]byte, 1024+48)
+	rand.Read(key)
+	msg := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		rand.Read(msg)
+		for n := 0; n <= len(msg); n += 16 {
+			var exp [32]byte
+			sumGeneric(&exp, msg[:n], key[:n+48])
+			for _, im := range impls {
+				var out [32]byte
+				im.sum(&out, msg[:n], key[:n+48])
+				if out != exp {
+					t.Fatalf("%v: hash of %v-byte message does not match generic implementation", im.name, n)
+				}
+			}
+		}
+	}
+}
+
+func TestSelectImpl(t *testing.T) {
+	if name := os.Getenv("ADIANTUM_NH_IMPL"); name != "" && sumImpl.name != name {
+		t.Fatalf("ADIANTUM_NH_IMPL=%v, but %v is in use; available: %v", name, sumImpl.name, implNames())
+	}
+	for _, im := range impls {
+		if got := selectImpl(im.name); got.name != im.name {
+			t.Errorf("selectImpl(%q) selected %v", im.name, got.name)
+		}
+	}
+	if got := selectImpl("bogus"); got.name != impls[0].name {
+		t.Errorf("selectImpl(%q) selected %v, expected the default %v", "bogus", got.name, impls[0].name)
+	}
+	if impls[len(impls)-1].name != "generic" {
+		t.Error("generic implementation is not available")
+	}
+}
+
+func implNames() []string {
+	var names []string
+	for _, im := range impls {
+		names = append(names, im.name)
+	}
+	return names
+}
+
+// chunkedSum hashes m the way Hash is specified to: in ChunkSize chunks, with
+// the final chunk copied and zero-padded.
+func chunkedSum(m, key []byte) []byte {
+	var hashes []byte
+	for len(m) > 0 {
+		n := len(m)
+		if n > ChunkSize {
+			n = ChunkSize
+		}
+		chunk := make([]byte, (n+15)&^15)
+		copy(chunk, m[:n])
 		var out [32]byte
-		Sum(&out, fromHex(test.Input.Message), fromHex(test.Input.Key))
-		if hex.EncodeToString(out[:]) != test.Hash {
-			t.Fatalf("%v (%v): Encryption failed:\nexp: %v\ngot: %x", test.Description, i, test.Hash, out[:])
+		Sum(&out, chunk, key)
+		hashes = append(hashes, out[:]...)
+		m = m[n:]
+	}
+	return hashes
+}
+
+func TestHash(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	msg := make([]byte, 3*ChunkSize+100)
+	rand.Read(msg)
+	for n := 0; n <= len(msg); n += 1 + n/8 {
+		exp := chunkedSum(msg[:n], key)
+		got, err := Hash(nil, msg[:n], key)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, exp) {
+			t.Fatalf("Hash of %v-byte message does not match chunked Sum", n)
+		}
+		// a key of exactly KeyLen bytes suffices
+		got, err = Hash([]byte("prefix"), msg[:n], key[:KeyLen(n)])
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, append([]byte("prefix"), exp...)) {
+			t.Fatalf("Hash of %v-byte message with short key does not match chunked Sum", n)
 		}
 	}
+
+	for _, n := range []int{1, 16, 17, 1024, 5000} {
+		if _, err := Hash(nil, make([]byte, n), make([]byte, KeyLen(n)-1)); err != ErrKeyTooShort {
+			t.Errorf("expected ErrKeyTooShort for %v-byte message, got %v", n, err)
+		}
+	}
+	if KeyLen(1<<20) != KeySize {
+		t.Errorf("KeyLen of a long message should be KeySize, got %v", KeyLen(1<<20))
+	}
 }
 
 func BenchmarkNH(b *testing.B) {
@@ -53,10 +138,14 @@ func BenchmarkNH(b *testing.B) {
 	rand.Read(msg)
 	key := make([]byte, len(msg)+48)
 	rand.Read(key)
-	b.SetBytes(int64(len(msg)))
-	b.ReportAllocs()
-	for i := 0; i < b.N; i++ {
-		var out [32]byte
-		Sum(&out, msg, key)
+	for _, im := range impls {
+		b.Run(im.name, func(b *testing.B) {
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			var out [32]byte
+			for i := 0; i < b.N; i++ {
+				im.sum(&out, msg, key)
+			}
+		})
 	}
 }
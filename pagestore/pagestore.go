@@ -0,0 +1,164 @@
+// Package pagestore provides length-preserving encryption of fixed-size
+// database pages.
+//
+// Each page is encrypted with HBSH, using the page number, encoded with
+// tweak.LE64, followed by a per-database salt as the tweak. Because
+// the encryption is length-preserving, page N of the plaintext is stored at
+// page N of the underlying device, so a Store can be placed directly beneath a
+// database pager, e.g. as the file layer of a pure-Go SQLite VFS.
+//
+// Optionally, a prefix of the first page can be left unencrypted. SQLite, for
+// instance, reads the page size from its 100-byte database header before it
+// reads any pages.
+package pagestore // import "lukechampine.com/adiantum/pagestore"
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/hbsh/tweak"
+)
+
+// MaxSaltSize is the maximum size of a salt. Together with the page number, it
+// fills Adiantum's 32-byte tweak.
+const MaxSaltSize = 24
+
+// A Device is the underlying storage for a Store.
+type Device interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// A Store is an encrypted page store. Its ReadAt and WriteAt methods operate on
+// plaintext, encrypting and decrypting whole pages of the underlying Device. It
+// is safe for concurrent use.
+type Store struct {
+	mu          sync.Mutex
+	dev         Device
+	cipher      *hbsh.HBSH
+	pageSize    int
+	plainHeader int
+	tweak       []byte
+	page        []byte
+}
+
+// PageSize returns the size of each page.
+func (s *Store) PageSize() int {
+	return s.pageSize
+}
+
+func (s *Store) setTweak(page int64) []byte {
+	// overwrite the page number, leaving the salt in place
+	tweak.LE64.AppendTweak(s.tweak[:0], uint64(page))
+	return s.tweak
+}
+
+// encrypted returns the portion of page that is encrypted.
+func (s *Store) encrypted(buf []byte, page int64) []byte {
+	if page == 0 {
+		return buf[s.plainHeader:]
+	}
+	return buf
+}
+
+// readPage reads and decrypts the specified page into s.page. It returns
+// io.EOF if the page does not exist.
+func (s *Store) readPage(page int64) error {
+	n, err := s.dev.ReadAt(s.page, page*int64(s.pageSize))
+	if n == 0 && err == io.EOF {
+		return io.EOF
+	} else if n < len(s.page) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	s.cipher.Decrypt(s.encrypted(s.page, page), s.setTweak(page))
+	return nil
+}
+
+// writePage encrypts s.page and writes it to the specified page.
+func (s *Store) writePage(page int64) error {
+	s.cipher.Encrypt(s.encrypted(s.page, page), s.setTweak(page))
+	_, err := s.dev.WriteAt(s.page, page*int64(s.pageSize))
+	return err
+}
+
+// ReadAt implements io.ReaderAt.
+func (s *Store) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("pagestore: negative offset")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for len(p) > 0 {
+		page := off / int64(s.pageSize)
+		pageOff := int(off % int64(s.pageSize))
+		if err := s.readPage(page); err != nil {
+			return n, err
+		}
+		c := copy(p, s.page[pageOff:])
+		n += c
+		p = p[c:]
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt. Writes that do not cover a whole page
+// require the page to be read and decrypted first; pages beyond the end of
+// the device are treated as zeros.
+func (s *Store) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("pagestore: negative offset")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for len(p) > 0 {
+		page := off / int64(s.pageSize)
+		pageOff := int(off % int64(s.pageSize))
+		if pageOff != 0 || len(p) < s.pageSize {
+			if err := s.readPage(page); err == io.EOF {
+				for i := range s.page {
+					s.page[i] = 0
+				}
+			} else if err != nil {
+				return n, err
+			}
+		}
+		c := copy(s.page[pageOff:], p)
+		if err := s.writePage(page); err != nil {
+			return n, err
+		}
+		n += c
+		p = p[c:]
+		off += int64(c)
+	}
+	return n, nil
+}
+
+// New returns a Store that encrypts pages of dev with c. The salt should be
+// unique to the database; it must not exceed MaxSaltSize bytes. The first
+// plainHeader bytes of the first page are stored unencrypted; the remainder of
+// the page must be at least 16 bytes.
+func New(dev Device, c *hbsh.HBSH, pageSize int, salt []byte, plainHeader int) *Store {
+	if pageSize < 16 {
+		panic("pagestore: page size must be at least 16 bytes")
+	} else if len(salt) > MaxSaltSize {
+		panic("pagestore: salt too long")
+	} else if plainHeader < 0 || pageSize-plainHeader < 16 {
+		panic("pagestore: invalid plaintext header size")
+	}
+	return &Store{
+		dev:         dev,
+		cipher:      c,
+		pageSize:    pageSize,
+		plainHeader: plainHeader,
+		tweak:       append(make([]byte, 8), salt...),
+		page:        make([]byte, pageSize),
+	}
+}
@@ -0,0 +1,130 @@
+package pagestore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	mrand "math/rand"
+	"testing"
+
+	"lukechampine.com/adiantum"
+)
+
+// memDevice is an in-memory Device that grows as needed.
+type memDevice struct {
+	data []byte
+}
+
+func (d *memDevice) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *memDevice) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(d.data) {
+		d.data = append(d.data, make([]byte, end-len(d.data))...)
+	}
+	return copy(d.data[off:], p), nil
+}
+
+func newStore(pageSize, plainHeader int) (*Store, *memDevice) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	salt := make([]byte, MaxSaltSize)
+	rand.Read(salt)
+	dev := new(memDevice)
+	return New(dev, adiantum.New(key), pageSize, salt, plainHeader), dev
+}
+
+func TestRandomWorkload(t *testing.T) {
+	const pageSize = 512
+	const numPages = 32
+	s, dev := newStore(pageSize, 100)
+	model := make([]byte, pageSize*numPages)
+	if _, err := s.WriteAt(model, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rng := mrand.New(mrand.NewSource(0))
+	for i := 0; i < 1000; i++ {
+		var off, n int
+		if rng.Intn(2) == 0 {
+			// whole pages
+			off = rng.Intn(numPages) * pageSize
+			n = (rng.Intn(3) + 1) * pageSize
+		} else {
+			off = rng.Intn(len(model))
+			n = rng.Intn(2*pageSize) + 1
+		}
+		if off+n > len(model) {
+			n = len(model) - off
+		}
+		if rng.Intn(2) == 0 {
+			buf := make([]byte, n)
+			rng.Read(buf)
+			if _, err := s.WriteAt(buf, int64(off)); err != nil {
+				t.Fatal(err)
+			}
+			copy(model[off:], buf)
+		} else {
+			buf := make([]byte, n)
+			if _, err := s.ReadAt(buf, int64(off)); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(buf, model[off:off+n]) {
+				t.Fatalf("ReadAt(%v, %v) returned wrong data", off, n)
+			}
+		}
+	}
+
+	// the header should be stored in the clear, and nothing else
+	if !bytes.Equal(dev.data[:100], model[:100]) {
+		t.Fatal("plaintext header was encrypted")
+	} else if bytes.Equal(dev.data[100:pageSize], model[100:pageSize]) {
+		t.Fatal("first page was not encrypted")
+	}
+	for i := 1; i < numPages; i++ {
+		if bytes.Equal(dev.data[i*pageSize:][:pageSize], model[i*pageSize:][:pageSize]) {
+			t.Fatalf("page %v was not encrypted", i)
+		}
+	}
+}
+
+func TestGrow(t *testing.T) {
+	s, _ := newStore(64, 0)
+	buf := make([]byte, 10)
+	if _, err := s.ReadAt(buf, 0); err != io.EOF {
+		t.Fatal("expected EOF reading empty store, got", err)
+	}
+	// partial write to a page beyond the end of the device
+	if _, err := s.WriteAt([]byte("hello"), 3*64+5); err != nil {
+		t.Fatal(err)
+	}
+	page := make([]byte, 64)
+	if _, err := s.ReadAt(page, 3*64); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(page[5:10], []byte("hello")) || page[0] != 0 {
+		t.Fatal("partial write produced wrong page:", page)
+	}
+	if _, err := s.ReadAt(page, 4*64); err != io.EOF {
+		t.Fatal("expected EOF reading past end, got", err)
+	}
+}
+
+func TestSalt(t *testing.T) {
+	// the same page under different salts should encrypt differently
+	key := make([]byte, 32)
+	c := adiantum.New(key)
+	page := make([]byte, 64)
+	var d1, d2 memDevice
+	New(&d1, c, 64, []byte("salt1"), 0).WriteAt(page, 64)
+	New(&d2, c, 64, []byte("salt2"), 0).WriteAt(page, 64)
+	if bytes.Equal(d1.data, d2.data) {
+		t.Fatal("salt did not affect ciphertext")
+	}
+}
@@ -0,0 +1,349 @@
+// Package reference implements Adiantum and HPolyC directly from the paper,
+// "Adiantum: length-preserving encryption for entry-level processors" by Paul
+// Crowley and Eric Biggers. It favors clarity over speed: there is no assembly,
+// all modular arithmetic uses math/big, and each step is annotated with the
+// corresponding equation from the specification. It is intended as an oracle
+// for testing the optimized implementations, not for production use.
+package reference // import "lukechampine.com/adiantum/reference"
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"math/big"
+	"math/bits"
+)
+
+// A Cipher is an HBSH cipher built from XChaCha, AES, and either the
+// NH+Poly1305 hash (Adiantum) or the Poly1305 hash (HPolyC).
+type Cipher struct {
+	streamKey []byte
+	rounds    int
+	blockKey  []byte
+	hash      func(tweak, msg []byte) []byte
+}
+
+// Encrypt returns the encryption of plaintext under tweak. The plaintext must
+// be at least 16 bytes; it is not modified.
+func (c *Cipher) Encrypt(plaintext, tweak []byte) []byte {
+	if len(plaintext) < 16 {
+		panic("reference: plaintext must be at least 16 bytes")
+	}
+	// P = P_L || P_R, where |P_R| = n = 16
+	pl, pr := plaintext[:len(plaintext)-16], plaintext[len(plaintext)-16:]
+	// P_M = P_R ⊞ H_{K_H}(T, P_L)
+	pm := add128(pr, c.hash(tweak, pl))
+	// C_M = E_{K_E}(P_M)
+	cm := make([]byte, 16)
+	aesCipher(c.blockKey).Encrypt(cm, pm)
+	// C_L = P_L ⊕ S_{K_S}(C_M)[;|P_L|]
+	cl := xor(pl, XChaCha(c.rounds, c.streamKey, streamNonce(cm), len(pl)))
+	// C_R = C_M ⊟ H_{K_H}(T, C_L)
+	cr := sub128(cm, c.hash(tweak, cl))
+	// C = C_L || C_R
+	return append(cl, cr...)
+}
+
+// Decrypt returns the decryption of ciphertext under tweak. The ciphertext
+// must be at least 16 bytes; it is not modified.
+func (c *Cipher) Decrypt(ciphertext, tweak []byte) []byte {
+	if len(ciphertext) < 16 {
+		panic("reference: ciphertext must be at least 16 bytes")
+	}
+	// C = C_L || C_R
+	cl, cr := ciphertext[:len(ciphertext)-16], ciphertext[len(ciphertext)-16:]
+	// C_M = C_R ⊞ H_{K_H}(T, C_L)
+	cm := add128(cr, c.hash(tweak, cl))
+	// P_L = C_L ⊕ S_{K_S}(C_M)[;|C_L|]
+	pl := xor(cl, XChaCha(c.rounds, c.streamKey, streamNonce(cm), len(cl)))
+	// P_M = D_{K_E}(C_M)
+	pm := make([]byte, 16)
+	aesCipher(c.blockKey).Decrypt(pm, cm)
+	// P_R = P_M ⊟ H_{K_H}(T, P_L)
+	pr := sub128(pm, c.hash(tweak, pl))
+	// P = P_L || P_R
+	return append(pl, pr...)
+}
+
+// Hash returns the output of the cipher's tweakable hash, H_{K_H}(T, M).
+func (c *Cipher) Hash(tweak, msg []byte) []byte {
+	return c.hash(tweak, msg)
+}
+
+// Adiantum returns the Adiantum cipher with the specified key, using XChaCha
+// with the specified number of rounds and AES-256. The key must be 32 bytes
+// long.
+func Adiantum(key []byte, rounds int) *Cipher {
+	return AdiantumVariant(key, rounds, 32)
+}
+
+// AdiantumVariant is like Adiantum, but uses AES with the specified key size
+// (16, 24, or 32 bytes).
+func AdiantumVariant(key []byte, rounds, aesKeySize int) *Cipher {
+	// K_E || K_T || K_M || K_N = S_K(1)[;|K_E|+16+16+1072]
+	kb := deriveKeys(key, rounds, aesKeySize+16+16+1072)
+	keyE, kb := kb[:aesKeySize], kb[aesKeySize:]
+	keyT, keyM, keyNH := kb[:16], kb[16:32], kb[32:]
+	return &Cipher{
+		streamKey: key,
+		rounds:    rounds,
+		blockKey:  keyE,
+		hash: func(tweak, msg []byte) []byte {
+			return AdiantumHash(keyT, keyM, keyNH, tweak, msg)
+		},
+	}
+}
+
+// HPolyC returns the HPolyC cipher with the specified key, using XChaCha with
+// the specified number of rounds and AES-256. The key must be 32 bytes long.
+func HPolyC(key []byte, rounds int) *Cipher {
+	return HPolyCVariant(key, rounds, 32)
+}
+
+// HPolyCVariant is like HPolyC, but uses AES with the specified key size (16,
+// 24, or 32 bytes).
+func HPolyCVariant(key []byte, rounds, aesKeySize int) *Cipher {
+	// K_E || K_H = S_K(1)[;|K_E|+16]
+	kb := deriveKeys(key, rounds, aesKeySize+16)
+	keyE, keyH := kb[:aesKeySize], kb[aesKeySize:]
+	return &Cipher{
+		streamKey: key,
+		rounds:    rounds,
+		blockKey:  keyE,
+		hash: func(tweak, msg []byte) []byte {
+			return HPolyCHash(keyH, tweak, msg)
+		},
+	}
+}
+
+// AdiantumHash computes the Adiantum hash of tweak and msg:
+//
+//	H_{K_H}(T, M) = Poly1305_{K_T}(int(8|M|) || T) ⊞ Poly1305_{K_M}(NH_{K_N}(pad(M)))
+//
+// where int(8|M|) is the 16-byte little-endian bit length of M, pad(M) appends
+// zeros to a multiple of 16 bytes, and NH is applied to each 1024-byte chunk.
+func AdiantumHash(keyT, keyM, keyNH, tweak, msg []byte) []byte {
+	lenBuf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(lenBuf, uint64(8*len(msg)))
+	hT := Poly1305(keyT, nil, append(lenBuf, tweak...))
+
+	padded := append([]byte(nil), msg...)
+	for len(padded)%16 != 0 {
+		padded = append(padded, 0)
+	}
+	var nhOut []byte
+	for i := 0; i < len(padded); i += 1024 {
+		end := i + 1024
+		if end > len(padded) {
+			end = len(padded)
+		}
+		nhOut = append(nhOut, NH(keyNH, padded[i:end])...)
+	}
+	hM := Poly1305(keyM, nil, nhOut)
+	return add128(hT, hM)
+}
+
+// HPolyCHash computes the HPolyC hash of tweak and msg:
+//
+//	H_{K_H}(T, M) = Poly1305_{K_H}(int(8|T|) || T || pad(...) || M)
+//
+// where int(8|T|) is the 4-byte little-endian bit length of T, and pad(...) is
+// 16 - (4+|T|) mod 16 zero bytes, so that the message begins on a 16-byte
+// boundary. This is the encoding of package hpolyc, which pads with a whole
+// block of zeros when 4+|T| is already a multiple of 16.
+func HPolyCHash(keyH, tweak, msg []byte) []byte {
+	in := make([]byte, 4)
+	binary.LittleEndian.PutUint32(in, uint32(8*len(tweak)))
+	in = append(in, tweak...)
+	in = append(in, make([]byte, 16-len(in)%16)...)
+	return Poly1305(keyH, nil, append(in, msg...))
+}
+
+// Poly1305 computes the Poly1305 MAC of msg using the 16-byte key r and the
+// 16-byte addend s. A nil s is treated as zero, as in Adiantum and HPolyC.
+//
+//	Poly1305_{r,s}(M) = ((Σ c_i·r^{q-i+1}) mod 2^130-5) + s mod 2^128
+func Poly1305(r, s, msg []byte) []byte {
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 130), big.NewInt(5))
+	clamped := append([]byte(nil), r[:16]...)
+	for _, i := range []int{3, 7, 11, 15} {
+		clamped[i] &= 15
+	}
+	for _, i := range []int{4, 8, 12} {
+		clamped[i] &= 252
+	}
+	rInt := leInt(clamped)
+
+	h := new(big.Int)
+	for i := 0; i < len(msg); i += 16 {
+		end := i + 16
+		if end > len(msg) {
+			end = len(msg)
+		}
+		// c_i = int(M_i || 1)
+		c := leInt(append(append([]byte(nil), msg[i:end]...), 1))
+		h.Add(h, c).Mul(h, rInt).Mod(h, p)
+	}
+	if s != nil {
+		h.Add(h, leInt(s[:16]))
+	}
+	return leBytes(h, 16)
+}
+
+// NH computes the 32-byte NH hash of msg, whose length must be a multiple of
+// 16 and at most 1024. The key must be at least len(msg)+48 bytes long.
+//
+//	NH_K(M)[i] = Σ_j ((m_{4j} + k_{4j+4i}) mod 2^32)·((m_{4j+2} + k_{4j+4i+2}) mod 2^32)
+//	           + ((m_{4j+1} + k_{4j+4i+1}) mod 2^32)·((m_{4j+3} + k_{4j+4i+3}) mod 2^32)
+//
+// for i in [0, 4), with each sum taken mod 2^64.
+func NH(key, msg []byte) []byte {
+	if len(msg)%16 != 0 || len(msg) > 1024 {
+		panic("reference: invalid NH message length")
+	}
+	word := func(b []byte, i int) uint64 { return uint64(binary.LittleEndian.Uint32(b[4*i:])) }
+	out := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		var sum uint64
+		for j := 0; j < len(msg)/4; j += 4 {
+			k := j + 4*i
+			sum += ((word(msg, j) + word(key, k)) % (1 << 32)) * ((word(msg, j+2) + word(key, k+2)) % (1 << 32))
+			sum += ((word(msg, j+1) + word(key, k+1)) % (1 << 32)) * ((word(msg, j+3) + word(key, k+3)) % (1 << 32))
+		}
+		binary.LittleEndian.PutUint64(out[8*i:], sum)
+	}
+	return out
+}
+
+// XChaCha returns n bytes of XChaCha keystream for the specified key and
+// 24-byte nonce: HChaCha derives a subkey from the first 16 bytes of the nonce,
+// and ChaCha, with a 64-bit block counter starting at zero, generates the
+// keystream from the subkey and the last 8 bytes of the nonce.
+func XChaCha(rounds int, key, nonce []byte, n int) []byte {
+	if len(key) != 32 {
+		panic("reference: key must be 32 bytes long")
+	} else if len(nonce) != 24 {
+		panic("reference: nonce must be 24 bytes long")
+	}
+	x := chachaState(key, nonce[:16])
+	chachaRounds(&x, rounds)
+	subkey := make([]byte, 32)
+	for i, w := range []uint32{x[0], x[1], x[2], x[3], x[12], x[13], x[14], x[15]} {
+		binary.LittleEndian.PutUint32(subkey[4*i:], w)
+	}
+
+	var stream []byte
+	for counter := uint64(0); len(stream) < n; counter++ {
+		blockNonce := make([]byte, 16)
+		binary.LittleEndian.PutUint64(blockNonce, counter)
+		copy(blockNonce[8:], nonce[16:])
+		in := chachaState(subkey, blockNonce)
+		out := in
+		chachaRounds(&out, rounds)
+		block := make([]byte, 64)
+		for i := range out {
+			binary.LittleEndian.PutUint32(block[4*i:], out[i]+in[i])
+		}
+		stream = append(stream, block...)
+	}
+	return stream[:n]
+}
+
+func chachaState(key, nonce []byte) [16]uint32 {
+	x := [16]uint32{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574}
+	for i := 0; i < 8; i++ {
+		x[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	for i := 0; i < 4; i++ {
+		x[12+i] = binary.LittleEndian.Uint32(nonce[4*i:])
+	}
+	return x
+}
+
+func chachaRounds(x *[16]uint32, rounds int) {
+	qr := func(a, b, c, d int) {
+		x[a] += x[b]
+		x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+		x[c] += x[d]
+		x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+		x[a] += x[b]
+		x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+		x[c] += x[d]
+		x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+	}
+	for i := 0; i < rounds; i += 2 {
+		qr(0, 4, 8, 12)
+		qr(1, 5, 9, 13)
+		qr(2, 6, 10, 14)
+		qr(3, 7, 11, 15)
+		qr(0, 5, 10, 15)
+		qr(1, 6, 11, 12)
+		qr(2, 7, 8, 13)
+		qr(3, 4, 9, 14)
+	}
+}
+
+// deriveKeys returns S_K(1)[;n], the first n bytes of keystream generated with
+// the nonce 1 || 0^184.
+func deriveKeys(key []byte, rounds, n int) []byte {
+	nonce := make([]byte, 24)
+	nonce[0] = 1
+	return XChaCha(rounds, key, nonce, n)
+}
+
+func aesCipher(key []byte) cipher.Block {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
+
+// streamNonce returns the nonce C_M || 1 || 0^56.
+func streamNonce(cm []byte) []byte {
+	nonce := make([]byte, 24)
+	copy(nonce, cm)
+	nonce[16] = 1
+	return nonce
+}
+
+func xor(x, y []byte) []byte {
+	z := make([]byte, len(x))
+	for i := range z {
+		z[i] = x[i] ^ y[i]
+	}
+	return z
+}
+
+// add128 returns x ⊞ y, addition of little-endian integers modulo 2^128.
+func add128(x, y []byte) []byte {
+	sum := new(big.Int).Add(leInt(x), leInt(y))
+	return leBytes(sum.Mod(sum, mod128), 16)
+}
+
+// sub128 returns x ⊟ y, subtraction of little-endian integers modulo 2^128.
+func sub128(x, y []byte) []byte {
+	diff := new(big.Int).Sub(leInt(x), leInt(y))
+	return leBytes(diff.Mod(diff, mod128), 16)
+}
+
+var mod128 = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// leInt interprets b as a little-endian integer.
+func leInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// leBytes returns the n least-significant bytes of x in little-endian order.
+func leBytes(x *big.Int, n int) []byte {
+	be := x.Bytes()
+	out := make([]byte, n)
+	for i := 0; i < len(be) && i < n; i++ {
+		out[i] = be[len(be)-1-i]
+	}
+	return out
+}
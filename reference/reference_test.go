@@ -0,0 +1,74 @@
+package reference_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"lukechampine.com/adiantum"
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/hpolyc"
+	"lukechampine.com/adiantum/internal/kat"
+	"lukechampine.com/adiantum/reference"
+)
+
+func TestVectors(t *testing.T) {
+	for _, rounds := range []int{8, 12, 20} {
+		for _, v := range []struct {
+			file string
+			new  func([]byte, int) *reference.Cipher
+		}{
+			{fmt.Sprintf("../testdata/Adiantum_XChaCha%v_32_AES256.json", rounds), reference.Adiantum},
+			{fmt.Sprintf("../hpolyc/testdata/HPolyC_XChaCha%v_32_AES256.json", rounds), reference.HPolyC},
+		} {
+			tests, err := kat.Load(v.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, test := range tests {
+				c := v.new(test.Input.Key, rounds)
+				tweak := test.Input.Tweak
+				plaintext, ciphertext := test.Plaintext, test.Ciphertext
+				if got := c.Encrypt(plaintext, tweak); !bytes.Equal(got, ciphertext) {
+					t.Fatalf("%v: Encrypt mismatch on vector %v (%v)", v.file, i, test.Description)
+				}
+				if got := c.Decrypt(ciphertext, tweak); !bytes.Equal(got, plaintext) {
+					t.Fatalf("%v: Decrypt mismatch on vector %v (%v)", v.file, i, test.Description)
+				}
+			}
+		}
+	}
+}
+
+func TestDifferential(t *testing.T) {
+	ciphers := []struct {
+		name string
+		ref  func([]byte, int) *reference.Cipher
+		opt  map[int]func([]byte) *hbsh.HBSH
+	}{
+		{"Adiantum", reference.Adiantum, map[int]func([]byte) *hbsh.HBSH{8: adiantum.New8, 12: adiantum.New, 20: adiantum.New20}},
+		{"HPolyC", reference.HPolyC, map[int]func([]byte) *hbsh.HBSH{8: hpolyc.New8, 12: hpolyc.New, 20: hpolyc.New20}},
+	}
+	r := rand.New(rand.NewSource(0))
+	for _, c := range ciphers {
+		for rounds, newOpt := range c.opt {
+			for i := 0; i < 20; i++ {
+				key := make([]byte, 32)
+				tweak := make([]byte, r.Intn(adiantum.MaxTweakSize+1))
+				plaintext := make([]byte, 16+r.Intn(5000))
+				r.Read(key)
+				r.Read(tweak)
+				r.Read(plaintext)
+				ref, opt := c.ref(key, rounds), newOpt(key)
+				exp := ref.Encrypt(plaintext, tweak)
+				if got := opt.Encrypt(append([]byte(nil), plaintext...), tweak); !bytes.Equal(got, exp) {
+					t.Fatalf("%v/XChaCha%v: Encrypt mismatch for %v-byte plaintext", c.name, rounds, len(plaintext))
+				}
+				if got := ref.Decrypt(exp, tweak); !bytes.Equal(got, plaintext) {
+					t.Fatalf("%v/XChaCha%v: reference Decrypt did not recover plaintext", c.name, rounds)
+				}
+			}
+		}
+	}
+}
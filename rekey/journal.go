@@ -0,0 +1,70 @@
+package rekey
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// A FileJournal is a Journal stored in a file. Each Save atomically replaces
+// the file's contents.
+type FileJournal struct {
+	path string
+}
+
+// Load implements Journal.
+func (fj *FileJournal) Load() (State, error) {
+	b, err := os.ReadFile(fj.path)
+	if os.IsNotExist(err) {
+		return State{}, nil
+	} else if err != nil {
+		return State{}, err
+	} else if len(b) < 8 {
+		return State{}, errors.New("rekey: journal file is truncated")
+	}
+	s := State{Watermark: binary.LittleEndian.Uint64(b[:8])}
+	if len(b) > 8 {
+		s.Pending = b[8:]
+	}
+	return s, nil
+}
+
+// Save implements Journal.
+func (fj *FileJournal) Save(s State) error {
+	// write to a temporary file, then rename it over the journal
+	tmp := fj.path + "_tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], s.Watermark)
+	if _, err := f.Write(buf[:]); err != nil {
+		f.Close()
+		return err
+	} else if _, err := f.Write(s.Pending); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	} else if err := os.Rename(tmp, fj.path); err != nil {
+		return err
+	}
+	// sync the directory so that the rename is durable
+	dir, err := os.Open(filepath.Dir(fj.path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// NewFileJournal returns a FileJournal stored at path. The file is created on
+// the first call to Save.
+func NewFileJournal(path string) *FileJournal {
+	return &FileJournal{path: path}
+}
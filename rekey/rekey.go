@@ -0,0 +1,257 @@
+// Package rekey re-encrypts a sector store from one HBSH key to another.
+//
+// A Rekeyer walks the store in batches of sectors, decrypting each with the
+// old cipher and encrypting it with the new one. Before a batch is overwritten,
+// its old ciphertext is recorded in a Journal; if the process crashes midway
+// through the batch, the next call to New restores the old ciphertext before
+// continuing, so no sector is ever encrypted twice. Sectors below the
+// watermark are encrypted with the new key, and sectors at or above it with the
+// old key; ReadSector and WriteSector consult the watermark, so the store can
+// remain online while it is being re-encrypted.
+package rekey // import "lukechampine.com/adiantum/rekey"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/hbsh/tweak"
+)
+
+// batchSectors is the number of sectors re-encrypted per journal entry.
+const batchSectors = 16
+
+// A Device is a sector store.
+type Device interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// State is the progress of a re-encryption.
+type State struct {
+	// Watermark is the index of the first sector still encrypted with the old
+	// key.
+	Watermark uint64
+	// Pending holds the old ciphertext of the sectors starting at Watermark,
+	// if a batch was being written when the State was saved.
+	Pending []byte
+}
+
+// A Journal durably records the progress of a re-encryption. Save must not
+// return until the State is durable. The Rekeyer does not modify s.Pending
+// after passing it to Save, so the Journal may retain it.
+type Journal interface {
+	// Load returns the most recently saved State, or the zero State if none
+	// has been saved.
+	Load() (State, error)
+	Save(State) error
+}
+
+// A Rekeyer re-encrypts a Device. It is safe for concurrent use.
+type Rekeyer struct {
+	mu         sync.Mutex
+	dev        Device
+	sectorSize int
+	numSectors uint64
+	oldCipher  *hbsh.HBSH
+	newCipher  *hbsh.HBSH
+	journal    Journal
+	watermark  uint64
+	buf        []byte
+	err        error // sticky; set if a batch fails midway
+}
+
+func (r *Rekeyer) cipherFor(sector uint64) *hbsh.HBSH {
+	if sector < r.watermark {
+		return r.newCipher
+	}
+	return r.oldCipher
+}
+
+func (r *Rekeyer) checkSector(buf []byte, sector uint64) error {
+	if len(buf) != r.sectorSize {
+		return fmt.Errorf("rekey: buffer is %v bytes, expected %v", len(buf), r.sectorSize)
+	} else if sector >= r.numSectors {
+		return fmt.Errorf("rekey: sector %v is out of range", sector)
+	}
+	return nil
+}
+
+// Watermark returns the index of the first sector still encrypted with the old
+// key.
+func (r *Rekeyer) Watermark() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.watermark
+}
+
+// Done reports whether every sector has been re-encrypted.
+func (r *Rekeyer) Done() bool {
+	return r.Watermark() >= r.numSectors
+}
+
+// ReadSector reads and decrypts the specified sector into dst, which must be
+// exactly one sector long.
+func (r *Rekeyer) ReadSector(dst []byte, sector uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	} else if err := r.checkSector(dst, sector); err != nil {
+		return err
+	}
+	if err := readFull(r.dev, dst, int64(sector)*int64(r.sectorSize)); err != nil {
+		return err
+	}
+	var tweakBuf [8]byte
+	r.cipherFor(sector).Decrypt(dst, sectorTweak(&tweakBuf, sector))
+	return nil
+}
+
+// WriteSector encrypts src and writes it to the specified sector. src must be
+// exactly one sector long; it is not modified.
+func (r *Rekeyer) WriteSector(src []byte, sector uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	} else if err := r.checkSector(src, sector); err != nil {
+		return err
+	}
+	buf := r.buf[:r.sectorSize]
+	copy(buf, src)
+	var tweakBuf [8]byte
+	r.cipherFor(sector).Encrypt(buf, sectorTweak(&tweakBuf, sector))
+	_, err := r.dev.WriteAt(buf, int64(sector)*int64(r.sectorSize))
+	return err
+}
+
+// Step re-encrypts the next batch of sectors. It returns io.EOF once every
+// sector has been re-encrypted.
+//
+// If Step fails after journaling a batch, the state of the batch is unknown,
+// and every subsequent method returns the same error. Call New again with the
+// same Journal to recover.
+func (r *Rekeyer) Step() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	} else if r.watermark >= r.numSectors {
+		return io.EOF
+	}
+	n := r.numSectors - r.watermark
+	if n > batchSectors {
+		n = batchSectors
+	}
+	buf := r.buf[:n*uint64(r.sectorSize)]
+	off := int64(r.watermark) * int64(r.sectorSize)
+	if err := readFull(r.dev, buf, off); err != nil {
+		return err
+	}
+	// journal the old ciphertext before overwriting it; buf is re-encrypted in
+	// place below, so the journal gets its own copy, which it may keep
+	pending := append([]byte(nil), buf...)
+	if err := r.journal.Save(State{Watermark: r.watermark, Pending: pending}); err != nil {
+		return err
+	}
+	var tweakBuf [8]byte
+	for i := uint64(0); i < n; i++ {
+		sector := buf[i*uint64(r.sectorSize):][:r.sectorSize]
+		t := sectorTweak(&tweakBuf, r.watermark+i)
+		r.oldCipher.Decrypt(sector, t)
+		r.newCipher.Encrypt(sector, t)
+	}
+	if _, err := r.dev.WriteAt(buf, off); err != nil {
+		r.err = err
+		return err
+	}
+	if err := syncDevice(r.dev); err != nil {
+		r.err = err
+		return err
+	}
+	if err := r.journal.Save(State{Watermark: r.watermark + n}); err != nil {
+		r.err = err
+		return err
+	}
+	r.watermark += n
+	return nil
+}
+
+// Run calls Step until every sector has been re-encrypted.
+func (r *Rekeyer) Run() error {
+	for {
+		if err := r.Step(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// New returns a Rekeyer that re-encrypts the first numSectors sectors of dev
+// from oldCipher to newCipher, recording its progress in j. If j contains a
+// partially-written batch, New restores its old ciphertext before returning.
+//
+// Each sector is encrypted using its index, encoded with tweak.LE64, as the
+// tweak.
+func New(dev Device, sectorSize int, numSectors uint64, oldCipher, newCipher *hbsh.HBSH, j Journal) (*Rekeyer, error) {
+	if sectorSize < 16 {
+		return nil, errors.New("rekey: sector size must be at least 16 bytes")
+	}
+	s, err := j.Load()
+	if err != nil {
+		return nil, err
+	}
+	if s.Watermark > numSectors {
+		return nil, errors.New("rekey: journal watermark exceeds device size")
+	} else if len(s.Pending)%sectorSize != 0 || uint64(len(s.Pending)/sectorSize) > numSectors-s.Watermark {
+		return nil, errors.New("rekey: journal contains an invalid pending batch")
+	}
+	if len(s.Pending) > 0 {
+		// a batch was interrupted; some of its sectors may have been
+		// re-encrypted, so restore all of them to the old ciphertext
+		if _, err := dev.WriteAt(s.Pending, int64(s.Watermark)*int64(sectorSize)); err != nil {
+			return nil, err
+		}
+		if err := syncDevice(dev); err != nil {
+			return nil, err
+		}
+		if err := j.Save(State{Watermark: s.Watermark}); err != nil {
+			return nil, err
+		}
+	}
+	return &Rekeyer{
+		dev:        dev,
+		sectorSize: sectorSize,
+		numSectors: numSectors,
+		oldCipher:  oldCipher,
+		newCipher:  newCipher,
+		journal:    j,
+		watermark:  s.Watermark,
+		buf:        make([]byte, batchSectors*sectorSize),
+	}, nil
+}
+
+// readFull reads len(buf) bytes from dev at off. io.ReaderAt permits a read
+// that reaches the end of the device to return io.EOF even if it is complete.
+func readFull(dev Device, buf []byte, off int64) error {
+	n, err := dev.ReadAt(buf, off)
+	if err == io.EOF && n == len(buf) {
+		err = nil
+	}
+	return err
+}
+
+func syncDevice(dev Device) error {
+	if s, ok := dev.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func sectorTweak(buf *[8]byte, sector uint64) []byte {
+	return tweak.LE64.AppendTweak(buf[:0], sector)
+}
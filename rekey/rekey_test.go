@@ -0,0 +1,212 @@
+package rekey
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"lukechampine.com/adiantum"
+	"lukechampine.com/adiantum/hbsh"
+)
+
+type memDevice struct {
+	data       []byte
+	writesLeft int // if > 0, fail once this many writes have succeeded
+}
+
+// ReadAt returns io.EOF with a read that reaches the end of the device, as
+// io.ReaderAt permits even when the read is complete.
+func (d *memDevice) ReadAt(p []byte, off int64) (int, error) {
+	n := copy(p, d.data[off:])
+	if off+int64(n) == int64(len(d.data)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (d *memDevice) WriteAt(p []byte, off int64) (int, error) {
+	if d.writesLeft > 0 {
+		d.writesLeft--
+		if d.writesLeft == 0 {
+			// simulate a torn write
+			copy(d.data[off:], p[:len(p)/2])
+			return len(p) / 2, errors.New("device failed")
+		}
+	}
+	return copy(d.data[off:], p), nil
+}
+
+type memJournal struct {
+	s State
+}
+
+func (j *memJournal) Load() (State, error) { return j.s, nil }
+
+// Save retains s.Pending without copying it, as Journal permits.
+func (j *memJournal) Save(s State) error {
+	j.s = s
+	return nil
+}
+
+func randKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// encryptDevice returns a device containing the encryption of plaintext under
+// c.
+func encryptDevice(c *hbsh.HBSH, plaintext []byte, sectorSize int) *memDevice {
+	data := append([]byte(nil), plaintext...)
+	for i := 0; i < len(data)/sectorSize; i++ {
+		tweak := make([]byte, 8)
+		binary.LittleEndian.PutUint64(tweak, uint64(i))
+		c.Encrypt(data[i*sectorSize:][:sectorSize], tweak)
+	}
+	return &memDevice{data: data}
+}
+
+func checkDevice(t *testing.T, r *Rekeyer, plaintext []byte, sectorSize int) {
+	t.Helper()
+	buf := make([]byte, sectorSize)
+	for i := 0; i < len(plaintext)/sectorSize; i++ {
+		if err := r.ReadSector(buf, uint64(i)); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf, plaintext[i*sectorSize:][:sectorSize]) {
+			t.Fatalf("sector %v decrypted incorrectly", i)
+		}
+	}
+}
+
+func TestRekey(t *testing.T) {
+	const sectorSize = 512
+	const numSectors = 100
+	plaintext := make([]byte, sectorSize*numSectors)
+	rand.Read(plaintext)
+	oldCipher, newCipher := adiantum.New(randKey()), adiantum.New(randKey())
+	dev := encryptDevice(oldCipher, plaintext, sectorSize)
+
+	r, err := New(dev, sectorSize, numSectors, oldCipher, newCipher, new(memJournal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Step(); err != nil {
+		t.Fatal(err)
+	} else if r.Watermark() != batchSectors {
+		t.Fatal("wrong watermark after one step:", r.Watermark())
+	}
+	// device should be readable while partially re-encrypted
+	checkDevice(t, r, plaintext, sectorSize)
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	} else if !r.Done() {
+		t.Fatal("rekey should be done")
+	}
+	checkDevice(t, r, plaintext, sectorSize)
+
+	// the device should now be encrypted with the new key
+	if !bytes.Equal(encryptDevice(newCipher, plaintext, sectorSize).data, dev.data) {
+		t.Fatal("device not encrypted with new key")
+	}
+}
+
+func TestRekeyCrash(t *testing.T) {
+	const sectorSize = 256
+	const numSectors = 50
+	plaintext := make([]byte, sectorSize*numSectors)
+	rand.Read(plaintext)
+	oldCipher, newCipher := adiantum.New(randKey()), adiantum.New(randKey())
+	dev := encryptDevice(oldCipher, plaintext, sectorSize)
+	j := new(memJournal)
+
+	r, err := New(dev, sectorSize, numSectors, oldCipher, newCipher, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.writesLeft = 3
+	if err := r.Run(); err == nil {
+		t.Fatal("expected device failure")
+	} else if err := r.ReadSector(make([]byte, sectorSize), 0); err == nil {
+		t.Fatal("expected sticky error after failure")
+	}
+	if len(j.s.Pending) == 0 {
+		t.Fatal("journal should contain a pending batch")
+	}
+
+	// resume
+	r, err = New(dev, sectorSize, numSectors, oldCipher, newCipher, j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDevice(t, r, plaintext, sectorSize)
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	checkDevice(t, r, plaintext, sectorSize)
+}
+
+func TestRekeyWrite(t *testing.T) {
+	const sectorSize = 128
+	const numSectors = 40
+	plaintext := make([]byte, sectorSize*numSectors)
+	rand.Read(plaintext)
+	oldCipher, newCipher := adiantum.New(randKey()), adiantum.New(randKey())
+	dev := encryptDevice(oldCipher, plaintext, sectorSize)
+
+	r, err := New(dev, sectorSize, numSectors, oldCipher, newCipher, new(memJournal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Step(); err != nil {
+		t.Fatal(err)
+	}
+	// write one sector on each side of the watermark
+	for _, i := range []int{0, numSectors - 1} {
+		sector := plaintext[i*sectorSize:][:sectorSize]
+		rand.Read(sector)
+		if err := r.WriteSector(sector, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkDevice(t, r, plaintext, sectorSize)
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	checkDevice(t, r, plaintext, sectorSize)
+
+	if err := r.WriteSector(plaintext[:sectorSize-1], 0); err == nil {
+		t.Fatal("expected error for short sector")
+	} else if err := r.ReadSector(plaintext[:sectorSize], numSectors); err == nil {
+		t.Fatal("expected error for out-of-range sector")
+	}
+}
+
+func TestFileJournal(t *testing.T) {
+	dir := t.TempDir()
+	j := NewFileJournal(filepath.Join(dir, "journal"))
+
+	if s, err := j.Load(); err != nil {
+		t.Fatal(err)
+	} else if s.Watermark != 0 || s.Pending != nil {
+		t.Fatal("expected empty state, got", s)
+	}
+	exp := State{Watermark: 7, Pending: []byte("pending ciphertext")}
+	if err := j.Save(exp); err != nil {
+		t.Fatal(err)
+	} else if s, err := j.Load(); err != nil {
+		t.Fatal(err)
+	} else if s.Watermark != exp.Watermark || !bytes.Equal(s.Pending, exp.Pending) {
+		t.Fatal("loaded state does not match saved state:", s)
+	}
+	if err := j.Save(State{Watermark: 8}); err != nil {
+		t.Fatal(err)
+	} else if s, err := j.Load(); err != nil {
+		t.Fatal(err)
+	} else if s.Watermark != 8 || s.Pending != nil {
+		t.Fatal("loaded state does not match saved state:", s)
+	}
+}
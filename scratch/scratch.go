@@ -0,0 +1,128 @@
+// Package scratch provides encrypted temporary files for spilling intermediate
+// data to disk.
+//
+// Each File is encrypted with a random key that exists only in memory, using
+// Adiantum with XChaCha8 (the ephemeral data does not warrant the extra
+// margin of XChaCha12) and the 4096-byte sector index as the tweak. The block
+// cipher is the bitsliced constant-time AES, whose key schedule, unlike that of
+// crypto/aes, can be erased. Closing the File deletes it and wipes the key and
+// every key derived from it, after which its contents are unrecoverable.
+package scratch // import "lukechampine.com/adiantum/scratch"
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"sync"
+
+	"lukechampine.com/adiantum"
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/pagestore"
+)
+
+// SectorSize is the unit of allocation and encryption.
+const SectorSize = 4096
+
+// ErrClosed is returned when using a File that has been closed.
+var ErrClosed = errors.New("scratch: file already closed")
+
+// A File is an encrypted temporary file. It is safe for concurrent use.
+type File struct {
+	mu     sync.Mutex
+	f      *os.File
+	cipher *hbsh.HBSH
+	store  *pagestore.Store
+	next   int64 // offset of the next unallocated sector
+}
+
+// Alloc reserves space for n bytes and returns its offset. The space is
+// rounded up to a whole number of sectors, so allocations never share a
+// sector, and the file is extended to cover it. Reading allocated space before
+// writing it returns unspecified data. Alloc panics if n is negative.
+func (f *File) Alloc(n int64) (int64, error) {
+	if n < 0 {
+		panic("scratch: negative allocation size")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.store == nil {
+		return 0, ErrClosed
+	}
+	off := f.next
+	next := off + (n+SectorSize-1)/SectorSize*SectorSize
+	if err := f.f.Truncate(next); err != nil {
+		return 0, err
+	}
+	f.next = next
+	return off, nil
+}
+
+func (f *File) checkRange(op string, n int, off int64) error {
+	if f.store == nil {
+		return ErrClosed
+	} else if off < 0 || off+int64(n) > f.next {
+		return errors.New("scratch: " + op + " outside allocated space")
+	}
+	return nil
+}
+
+// WriteAt encrypts p and writes it at the specified offset, which must lie
+// within allocated space. Writes that do not cover whole sectors must first
+// read and decrypt the surrounding sectors.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.checkRange("write", len(p), off); err != nil {
+		return 0, err
+	}
+	return f.store.WriteAt(p, off)
+}
+
+// ReadAt reads and decrypts len(p) bytes at the specified offset, which must
+// lie within allocated space.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.checkRange("read", len(p), off); err != nil {
+		return 0, err
+	}
+	return f.store.ReadAt(p, off)
+}
+
+// Close deletes the file and wipes its keys from memory.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.store == nil {
+		return ErrClosed
+	}
+	f.cipher.Wipe()
+	f.store = nil
+	err := f.f.Close()
+	if rerr := os.Remove(f.f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// New creates a new File in dir. If dir is the empty string, the default
+// directory for temporary files is used.
+func New(dir string) (*File, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "scratch-*")
+	if err != nil {
+		return nil, err
+	}
+	c := adiantum.NewWithConfig(key, adiantum.Config{Rounds: 8, ConstantTimeAES: true})
+	for i := range key {
+		key[i] = 0
+	}
+	return &File{
+		f:      f,
+		cipher: c,
+		store:  pagestore.New(f, c, SectorSize, nil, 0),
+	}, nil
+}
@@ -0,0 +1,112 @@
+package scratch
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	f, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := f.f.Name()
+
+	bufs := [][]byte{make([]byte, 10), make([]byte, SectorSize), make([]byte, 3*SectorSize+17)}
+	offs := make([]int64, len(bufs))
+	for i, buf := range bufs {
+		rand.Read(buf)
+		offs[i], err = f.Alloc(int64(len(buf)))
+		if err != nil {
+			t.Fatal(err)
+		} else if offs[i]%SectorSize != 0 {
+			t.Fatal("allocation not sector-aligned:", offs[i])
+		}
+		if _, err := f.WriteAt(buf, offs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// overwrite part of the middle of a sector
+	copy(bufs[2][100:], "overwritten")
+	if _, err := f.WriteAt([]byte("overwritten"), offs[2]+100); err != nil {
+		t.Fatal(err)
+	}
+	for i, buf := range bufs {
+		got := make([]byte, len(buf))
+		if _, err := f.ReadAt(got, offs[i]); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(got, buf) {
+			t.Fatalf("buffer %v read back incorrectly", i)
+		}
+	}
+
+	// the file should not contain plaintext
+	ciphertext, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	} else if bytes.Contains(ciphertext, []byte("overwritten")) {
+		t.Fatal("file contains plaintext")
+	}
+
+	end, err := f.Alloc(0)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt(make([]byte, 1), end); err == nil {
+		t.Fatal("expected error writing outside allocated space")
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Fatal("file was not deleted")
+	} else if !f.cipher.Wipe() {
+		t.Fatal("cipher has primitives that cannot be wiped")
+	} else if _, err := f.ReadAt(make([]byte, 1), 0); err != ErrClosed {
+		t.Fatal("expected ErrClosed, got", err)
+	} else if _, err := f.Alloc(10); err != ErrClosed {
+		t.Fatal("expected ErrClosed, got", err)
+	} else if err := f.Close(); err != ErrClosed {
+		t.Fatal("expected ErrClosed, got", err)
+	}
+}
+
+func TestReadUnwritten(t *testing.T) {
+	f, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// a trailing sector that was allocated but never written must be readable
+	if _, err := f.Alloc(10); err != nil {
+		t.Fatal(err)
+	}
+	off, err := f.Alloc(2 * SectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("first sector"), off); err != nil {
+		t.Fatal(err)
+	}
+	for _, off := range []int64{0, off + SectorSize} {
+		if n, err := f.ReadAt(make([]byte, SectorSize), off); n != SectorSize || err != nil {
+			t.Fatalf("reading unwritten sector at %v: got %v, %v", off, n, err)
+		}
+	}
+}
+
+func TestAllocNegative(t *testing.T) {
+	f, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for negative allocation")
+		}
+	}()
+	f.Alloc(-1)
+}
@@ -0,0 +1,184 @@
+// Package suite maps canonical identifiers, such as "adiantum-xchacha12-aes256",
+// to HBSH cipher constructors, so that the variant used to encrypt data can be
+// recorded alongside it.
+//
+// Identifiers have the form <cipher>-xchacha<rounds>-aes<bits>, matching the
+// cipher descriptions in the Adiantum and HPolyC test vector files.
+package suite // import "lukechampine.com/adiantum/suite"
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"lukechampine.com/adiantum"
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/hpolyc"
+)
+
+// ErrUnknownSuite is returned when an identifier does not name a registered
+// suite.
+var ErrUnknownSuite = errors.New("suite: unknown cipher suite")
+
+// A Suite is a named HBSH variant.
+type Suite struct {
+	// Name is the canonical identifier of the suite. It must be lowercase.
+	Name string
+	// Cipher is "Adiantum" or "HPolyC".
+	Cipher string
+	// Rounds is the number of XChaCha rounds.
+	Rounds int
+	// BlockKeySize is the AES key size, in bytes.
+	BlockKeySize int
+	// New returns an instance of the suite with the specified 32-byte key.
+	New func(key []byte) *hbsh.HBSH
+}
+
+// Name returns the canonical identifier for the specified parameters.
+func Name(cipher string, rounds, blockKeySize int) string {
+	return fmt.Sprintf("%v-xchacha%v-aes%v", strings.ToLower(cipher), rounds, 8*blockKeySize)
+}
+
+func adiantumSuite(rounds, blockKeySize int) Suite {
+	return Suite{
+		Name:         Name("Adiantum", rounds, blockKeySize),
+		Cipher:       "Adiantum",
+		Rounds:       rounds,
+		BlockKeySize: blockKeySize,
+		New: func(key []byte) *hbsh.HBSH {
+			return adiantum.NewWithConfig(key, adiantum.Config{Rounds: rounds, BlockKeySize: blockKeySize})
+		},
+	}
+}
+
+func hpolycSuite(rounds, blockKeySize int) Suite {
+	return Suite{
+		Name:         Name("HPolyC", rounds, blockKeySize),
+		Cipher:       "HPolyC",
+		Rounds:       rounds,
+		BlockKeySize: blockKeySize,
+		New: func(key []byte) *hbsh.HBSH {
+			return hpolyc.NewWithConfig(key, hpolyc.Config{Rounds: rounds, BlockKeySize: blockKeySize})
+		},
+	}
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Suite)
+)
+
+func init() {
+	for _, s := range []Suite{
+		adiantumSuite(8, 32),
+		adiantumSuite(12, 32),
+		adiantumSuite(20, 32),
+		adiantumSuite(8, 16),
+		adiantumSuite(12, 16),
+		adiantumSuite(20, 16),
+		hpolycSuite(8, 32),
+		hpolycSuite(12, 32),
+		hpolycSuite(20, 32),
+		hpolycSuite(8, 16),
+		hpolycSuite(12, 16),
+		hpolycSuite(20, 16),
+	} {
+		Register(s)
+	}
+}
+
+// Register adds s to the registry. It panics if s has no name or constructor,
+// if the name is not lowercase, or if a suite with the same name is already
+// registered.
+func Register(s Suite) {
+	if s.Name == "" || s.New == nil {
+		panic("suite: Register requires a name and constructor")
+	} else if s.Name != strings.ToLower(s.Name) {
+		panic("suite: name must be lowercase: " + s.Name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[s.Name]; ok {
+		panic("suite: Register called twice for " + s.Name)
+	}
+	registry[s.Name] = s
+}
+
+// Lookup returns the suite with the specified identifier. Identifiers are
+// case-insensitive.
+func Lookup(name string) (Suite, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	s, ok := registry[strings.ToLower(name)]
+	if !ok {
+		return Suite{}, fmt.Errorf("%w %q", ErrUnknownSuite, name)
+	}
+	return s, nil
+}
+
+// All returns every registered suite, sorted by name.
+func All() []Suite {
+	mu.RLock()
+	defer mu.RUnlock()
+	suites := make([]Suite, 0, len(registry))
+	for _, s := range registry {
+		suites = append(suites, s)
+	}
+	sort.Slice(suites, func(i, j int) bool { return suites[i].Name < suites[j].Name })
+	return suites
+}
+
+// ParseKernel returns the suite named by a Linux cipher specification, along
+// with its IV mode, if any. It accepts dm-crypt specifications such as
+// "xchacha12,aes-adiantum-plain64", the bare "xchacha12,aes-adiantum", and
+// crypto API templates such as "adiantum(xchacha12,aes)", optionally prefixed
+// with "capi:". The kernel only implements Adiantum with AES-256 and the
+// NH-Poly1305 hash, which may be named explicitly.
+func ParseKernel(spec string) (s Suite, ivMode string, err error) {
+	fail := func() (Suite, string, error) {
+		return Suite{}, "", fmt.Errorf("%w: unrecognized kernel specification %q", ErrUnknownSuite, spec)
+	}
+	var params, suffix string
+	if rest := strings.TrimPrefix(spec, "capi:"); strings.HasPrefix(rest, "adiantum(") {
+		// crypto API template: adiantum(xchacha12,aes)[-ivmode]
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			return fail()
+		}
+		params, suffix = rest[len("adiantum("):end], rest[end+1:]
+	} else {
+		// dm-crypt: xchacha12,aes-adiantum[-ivmode]
+		i := strings.Index(spec, "-adiantum")
+		if i < 0 {
+			return fail()
+		}
+		params, suffix = spec[:i], spec[i+len("-adiantum"):]
+	}
+	if suffix != "" {
+		if !strings.HasPrefix(suffix, "-") || len(suffix) == 1 {
+			return fail()
+		}
+		ivMode = suffix[1:]
+	}
+
+	parts := strings.Split(params, ",")
+	if len(parts) == 3 && parts[2] == "nhpoly1305" {
+		parts = parts[:2]
+	}
+	if len(parts) != 2 || parts[1] != "aes" {
+		return fail()
+	}
+	var rounds int
+	switch parts[0] {
+	case "xchacha12":
+		rounds = 12
+	case "xchacha20":
+		rounds = 20
+	default:
+		return fail()
+	}
+	s, err = Lookup(Name("Adiantum", rounds, 32))
+	return s, ivMode, err
+}
@@ -0,0 +1,149 @@
+package suite
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"lukechampine.com/adiantum"
+	"lukechampine.com/adiantum/hbsh"
+	"lukechampine.com/adiantum/hpolyc"
+	"lukechampine.com/adiantum/internal/kat"
+)
+
+func TestVectors(t *testing.T) {
+	// every test vector file should name a registered suite
+	files, _ := filepath.Glob("../testdata/*.json")
+	hfiles, _ := filepath.Glob("../hpolyc/testdata/*.json")
+	files = append(files, hfiles...)
+	if len(files) == 0 {
+		t.Fatal("no test vector files found")
+	}
+	for _, file := range files {
+		vs, err := kat.Load(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec := vs[0].Cipher
+		s, err := Lookup(Name(spec.Cipher, spec.StreamCipher.Rounds, spec.BlockCipher.Lengths.Key))
+		if err != nil {
+			t.Fatalf("%v: %v", file, err)
+		}
+		for i, v := range vs {
+			c := s.New(v.Input.Key)
+			if got := c.Encrypt(append([]byte(nil), v.Plaintext...), v.Input.Tweak); !bytes.Equal(got, v.Ciphertext) {
+				t.Fatalf("%v: %v (%v): Encryption failed", s.Name, v.Description, i)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	key := make([]byte, 32)
+	block := make([]byte, 64)
+	for _, test := range []struct {
+		name string
+		exp  func([]byte) *hbsh.HBSH
+	}{
+		{"adiantum-xchacha12-aes256", adiantum.New},
+		{"Adiantum-XChaCha8-AES256", adiantum.New8},
+		{"hpolyc-xchacha20-aes256", hpolyc.New20},
+	} {
+		s, err := Lookup(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := test.exp(key).Encrypt(append([]byte(nil), block...), nil)
+		if got := s.New(key).Encrypt(append([]byte(nil), block...), nil); !bytes.Equal(got, exp) {
+			t.Errorf("%v: constructor mismatch", test.name)
+		}
+	}
+	if _, err := Lookup("adiantum-xchacha16-aes256"); !errors.Is(err, ErrUnknownSuite) {
+		t.Error("expected ErrUnknownSuite, got", err)
+	}
+
+	all := All()
+	if len(all) != 12 {
+		t.Errorf("expected 12 suites, got %v", len(all))
+	}
+	for i := range all {
+		if i > 0 && all[i-1].Name >= all[i].Name {
+			t.Fatal("suites are not sorted")
+		}
+		if all[i].Name != Name(all[i].Cipher, all[i].Rounds, all[i].BlockKeySize) {
+			t.Errorf("%v: name does not match parameters", all[i].Name)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	s := Suite{
+		Name: "test-suite",
+		New:  adiantum.New,
+	}
+	Register(s)
+	defer func() {
+		mu.Lock()
+		delete(registry, s.Name)
+		mu.Unlock()
+	}()
+	for _, name := range []string{"test-suite", "Test-Suite", "TEST-SUITE"} {
+		if _, err := Lookup(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bads := []Suite{
+		s,
+		{Name: "no-constructor"},
+		{New: adiantum.New},
+		{Name: "My-Cipher", New: adiantum.New}, // would be unreachable by Lookup
+	}
+	for _, bad := range bads {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic registering %q", bad.Name)
+				}
+			}()
+			Register(bad)
+		}()
+	}
+}
+
+func TestParseKernel(t *testing.T) {
+	for _, test := range []struct {
+		spec   string
+		name   string
+		ivMode string
+	}{
+		{"xchacha12,aes-adiantum-plain64", "adiantum-xchacha12-aes256", "plain64"},
+		{"xchacha20,aes-adiantum-plain64", "adiantum-xchacha20-aes256", "plain64"},
+		{"xchacha12,aes-adiantum", "adiantum-xchacha12-aes256", ""},
+		{"adiantum(xchacha12,aes)", "adiantum-xchacha12-aes256", ""},
+		{"capi:adiantum(xchacha20,aes)-plain64", "adiantum-xchacha20-aes256", "plain64"},
+		{"capi:adiantum(xchacha12,aes,nhpoly1305)-plain64", "adiantum-xchacha12-aes256", "plain64"},
+	} {
+		s, ivMode, err := ParseKernel(test.spec)
+		if err != nil {
+			t.Errorf("%v: %v", test.spec, err)
+		} else if s.Name != test.name || ivMode != test.ivMode {
+			t.Errorf("%v: expected (%v, %q), got (%v, %q)", test.spec, test.name, test.ivMode, s.Name, ivMode)
+		}
+	}
+	for _, spec := range []string{
+		"",
+		"aes-xts-plain64",
+		"xchacha8,aes-adiantum-plain64",
+		"xchacha12,aes128-adiantum",
+		"xchacha12,aes-adiantum-",
+		"xchacha12,aes-adiantumplain64",
+		"adiantum(xchacha12,aes",
+		"adiantum(xchacha12,aes)plain64",
+		"adiantum(xchacha12,aes,poly1305)",
+	} {
+		if _, _, err := ParseKernel(spec); !errors.Is(err, ErrUnknownSuite) {
+			t.Errorf("%q: expected ErrUnknownSuite, got %v", spec, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package umac
+
+import "math/bits"
+
+// The L2 hash evaluates a polynomial over the L1 hashes, using 64-bit words
+// modulo p64 for the first 2^14 hashes (2^24 bytes of message) and 128-bit
+// words modulo p128 for the rest.
+const (
+	p64      = 1<<64 - 59
+	l2Words  = 1 << 14 // 64-bit words hashed modulo p64
+	maxWord  = 1<<64 - 1<<32
+	marker64 = p64 - 1
+)
+
+// p128 and marker128 are 2^128 - 159 and p128 - 1.
+var (
+	p128      = uint128{hi: 1<<64 - 1, lo: 1<<64 - 159}
+	marker128 = uint128{hi: 1<<64 - 1, lo: 1<<64 - 160}
+)
+
+type uint128 struct{ hi, lo uint64 }
+
+// poly is the state of an L2 hash.
+type poly struct {
+	k64  uint64
+	k128 uint128
+	y    uint128
+	n    int    // number of words written
+	half uint64 // first half of a pending 128-bit word, if n > l2Words and n-l2Words is odd
+}
+
+// write adds a word of L1 output to the hash.
+func (p *poly) write(m uint64) {
+	p.n++
+	switch {
+	case p.n <= l2Words:
+		p.y.lo = polyStep64(p.y.lo, p.k64, m)
+	case p.n == l2Words+1:
+		// start over modulo p128, beginning with the hash so far
+		p.y = poly128(uint128{lo: 1}, p.k128, p.y)
+		p.half = m
+	case (p.n-l2Words)%2 == 1:
+		p.half = m
+	default:
+		p.y = polyStep128(p.y, p.k128, uint128{hi: p.half, lo: m})
+	}
+}
+
+// sum returns the hash of the words written so far. It must be called only
+// once.
+func (p *poly) sum() uint128 {
+	if p.n <= l2Words {
+		return p.y
+	}
+	// append 0x80 and pad with zeros to a 128-bit boundary
+	m := uint128{hi: 1 << 63}
+	if (p.n-l2Words)%2 == 1 {
+		m = uint128{hi: p.half, lo: 1 << 63}
+	}
+	return polyStep128(p.y, p.k128, m)
+}
+
+// polyStep64 absorbs one word into a POLY(64, 2^64 - 2^32, k, M) evaluation.
+func polyStep64(y, k, m uint64) uint64 {
+	if m >= maxWord {
+		return poly64(poly64(y, k, marker64), k, m-(1<<64-p64))
+	}
+	return poly64(y, k, m)
+}
+
+// polyStep128 absorbs one word into a POLY(128, 2^128 - 2^96, k, M)
+// evaluation.
+func polyStep128(y, k, m uint128) uint128 {
+	if m.hi >= maxWord {
+		lo, b := bits.Sub64(m.lo, 159, 0)
+		return poly128(poly128(y, k, marker128), k, uint128{hi: m.hi - b, lo: lo})
+	}
+	return poly128(y, k, m)
+}
+
+// poly64 returns (k*y + m) mod p64, where y < p64 and k < 2^57.
+func poly64(y, k, m uint64) uint64 {
+	// 2^64 ≡ 59 (mod p64)
+	hi, lo := bits.Mul64(k, y)
+	lo, c1 := bits.Add64(lo, hi*59, 0)
+	lo, c2 := bits.Add64(lo, m, 0)
+	lo, c3 := bits.Add64(lo, (c1+c2)*59, 0)
+	lo += c3 * 59
+	if lo >= p64 {
+		lo -= p64
+	}
+	return lo
+}
+
+// poly128 returns (k*y + m) mod p128, where y < p128 and each 32-bit word of k
+// is less than 2^25.
+func poly128(y, k, m uint128) uint128 {
+	// 256-bit product
+	h00, l00 := bits.Mul64(k.lo, y.lo)
+	h01, l01 := bits.Mul64(k.lo, y.hi)
+	h10, l10 := bits.Mul64(k.hi, y.lo)
+	h11, l11 := bits.Mul64(k.hi, y.hi)
+	var r1, r2, r3, c uint64
+	r0 := l00
+	r1, c = bits.Add64(h00, l01, 0)
+	r2, c = bits.Add64(h01, l11, c)
+	r3 = h11 + c
+	r1, c = bits.Add64(r1, l10, 0)
+	r2, c = bits.Add64(r2, h10, c)
+	r3 += c
+
+	// 2^128 ≡ 159 (mod p128)
+	a1, a0 := bits.Mul64(r2, 159)
+	b1, b0 := bits.Mul64(r3, 159)
+	s0, c := bits.Add64(r0, m.lo, 0)
+	s1, c := bits.Add64(r1, m.hi, c)
+	s2 := c
+	s0, c = bits.Add64(s0, a0, 0)
+	s1, c = bits.Add64(s1, a1, c)
+	s2 += c
+	s1, c = bits.Add64(s1, b0, 0)
+	s2 += b1 + c
+
+	s0, c = bits.Add64(s0, s2*159, 0)
+	s1, c = bits.Add64(s1, 0, c)
+	s0, c = bits.Add64(s0, c*159, 0)
+	s1 += c
+	if s1 == p128.hi && s0 >= p128.lo {
+		s1, s0 = 0, s0-p128.lo
+	}
+	return uint128{hi: s1, lo: s0}
+}
@@ -0,0 +1,254 @@
+// Package umac implements UMAC, the message authentication code specified in
+// RFC 4418, with 4-, 8-, 12-, and 16-byte tags (UMAC-32, UMAC-64, UMAC-96, and
+// UMAC-128).
+//
+// UMAC hashes the message with UHASH, a three-layer universal hash: NH (L1)
+// compresses each 1024-byte chunk to 8 bytes, a polynomial hash (L2) reduces
+// the chunk hashes to 16 bytes, and an inner-product hash (L3) reduces those
+// to 4 bytes. UHASH is computed once per 4 bytes of tag, with independent keys.
+// The tag is the hash XORed with a pad derived from the nonce with AES-128.
+//
+// UMAC's NH is not the NH of the nh package, which is Adiantum's; see the
+// nh package documentation.
+package umac // import "lukechampine.com/adiantum/umac"
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"lukechampine.com/adiantum/internal/ctaes"
+)
+
+const (
+	// KeySize is the size of a UMAC key.
+	KeySize = 16
+
+	// MaxNonceSize is the maximum size of a UMAC nonce. Nonces may be 1 to 16
+	// bytes long.
+	MaxNonceSize = 16
+
+	// chunkSize is the number of message bytes hashed by each NH call.
+	chunkSize = 1024
+
+	// maxIters is the number of UHASH iterations for a 16-byte tag.
+	maxIters = 4
+)
+
+// A UMAC computes UMAC tags of a fixed size. As with any Wegman–Carter MAC, a
+// nonce must never be used for two different messages with the same key.
+//
+// A UMAC is safe for concurrent use.
+type UMAC struct {
+	tagSize int
+	iters   int
+	pdf     cipher.Block // keyed with K' = KDF(K, 0, 16)
+
+	l1Key    [chunkSize/4 + 4*(maxIters-1)]uint32
+	l2Key64  [maxIters]uint64
+	l2Key128 [maxIters]uint128
+	l3Key1   [maxIters][8]uint64
+	l3Key2   [maxIters]uint32
+}
+
+// New returns a UMAC with the specified 16-byte key, producing tags of the
+// specified size, which must be 4, 8, 12, or 16.
+func New(key []byte, tagSize int) *UMAC {
+	if len(key) != KeySize {
+		panic("umac: key must be 16 bytes long")
+	} else if tagSize != 4 && tagSize != 8 && tagSize != 12 && tagSize != 16 {
+		panic("umac: tag size must be 4, 8, 12, or 16")
+	}
+	iters := tagSize / 4
+	u := &UMAC{tagSize: tagSize, iters: iters}
+	block := newAES(key)
+	u.pdf = newAES(kdf(block, 0, 16))
+
+	l1Key := kdf(block, 1, chunkSize+16*(iters-1))
+	for i := 0; i < len(l1Key)/4; i++ {
+		u.l1Key[i] = binary.BigEndian.Uint32(l1Key[i*4:])
+	}
+	const mask64 = 0x01FFFFFF01FFFFFF
+	l2Key := kdf(block, 2, 24*iters)
+	l3Key1 := kdf(block, 3, 64*iters)
+	l3Key2 := kdf(block, 4, 4*iters)
+	for i := 0; i < iters; i++ {
+		k := l2Key[24*i:]
+		u.l2Key64[i] = binary.BigEndian.Uint64(k[0:]) & mask64
+		u.l2Key128[i] = uint128{
+			hi: binary.BigEndian.Uint64(k[8:]) & mask64,
+			lo: binary.BigEndian.Uint64(k[16:]) & mask64,
+		}
+		for j := range u.l3Key1[i] {
+			u.l3Key1[i][j] = binary.BigEndian.Uint64(l3Key1[64*i+8*j:]) % p36
+		}
+		u.l3Key2[i] = binary.BigEndian.Uint32(l3Key2[4*i:])
+	}
+	return u
+}
+
+// Size returns the size of the tags computed by u.
+func (u *UMAC) Size() int { return u.tagSize }
+
+// Sum appends the tag of msg under the specified nonce to dst and returns the
+// resulting slice. The nonce must be 1 to 16 bytes long.
+func (u *UMAC) Sum(dst, nonce, msg []byte) []byte {
+	if len(nonce) < 1 || len(nonce) > MaxNonceSize {
+		panic("umac: nonce must be 1 to 16 bytes long")
+	}
+	// the pad is computed in place, in a full AES block appended to dst
+	n := len(dst)
+	dst = append(dst, make([]byte, 16)...)
+	tag := dst[n:]
+	u.pad(tag, nonce)
+	hash := u.uhash(msg)
+	for i := 0; i < u.iters; i++ {
+		binary.BigEndian.PutUint32(tag[4*i:], binary.BigEndian.Uint32(tag[4*i:])^hash[i])
+	}
+	return dst[:n+u.tagSize]
+}
+
+// Verify reports whether tag is the tag of msg under the specified nonce. The
+// comparison takes constant time.
+func (u *UMAC) Verify(nonce, msg, tag []byte) bool {
+	var buf [16]byte
+	return subtle.ConstantTimeCompare(u.Sum(buf[:0], nonce, msg), tag) == 1
+}
+
+// newAES returns an AES-128 cipher. It uses the bitsliced constant-time
+// implementation on CPUs without AES instructions.
+func newAES(key []byte) cipher.Block {
+	block, err := ctaes.NewAES(key)
+	if err != nil {
+		panic(err) // unreachable; key is always 16 bytes
+	}
+	return block
+}
+
+// kdf implements KDF(K, index, numbytes), where block is keyed with K.
+func kdf(block cipher.Block, index uint64, n int) []byte {
+	out := make([]byte, (n+15)/16*16)
+	for i := 0; i < len(out)/16; i++ {
+		t := out[16*i:][:16]
+		binary.BigEndian.PutUint64(t[0:], index)
+		binary.BigEndian.PutUint64(t[8:], uint64(i+1))
+		block.Encrypt(t, t)
+	}
+	return out[:n]
+}
+
+// pad writes PDF(K, nonce, taglen) to the start of out, which must be 16
+// bytes, and zeros the rest.
+func (u *UMAC) pad(out []byte, nonce []byte) {
+	copy(out, nonce)
+	for i := len(nonce); i < len(out); i++ {
+		out[i] = 0
+	}
+	// 4- and 8-byte tags use part of the AES output, selected by the low bits
+	// of the nonce, so that consecutive nonces share an AES call
+	var index int
+	if u.tagSize == 4 || u.tagSize == 8 {
+		mask := byte(16/u.tagSize - 1)
+		index = int(out[len(nonce)-1] & mask)
+		out[len(nonce)-1] &^= mask
+	}
+	u.pdf.Encrypt(out, out)
+	copy(out, out[index*u.tagSize:][:u.tagSize])
+	for i := u.tagSize; i < len(out); i++ {
+		out[i] = 0
+	}
+}
+
+// uhash computes UHASH(K, msg, taglen), as one 32-bit word per iteration.
+func (u *UMAC) uhash(msg []byte) (y [maxIters]uint32) {
+	var l2 [maxIters]poly
+	for i := range l2 {
+		l2[i] = poly{k64: u.l2Key64[i], k128: u.l2Key128[i], y: uint128{lo: 1}}
+	}
+	long := len(msg) > chunkSize
+	var a [maxIters]uint64
+	for {
+		chunk := msg
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		u.l1(&a, chunk)
+		msg = msg[len(chunk):]
+		if !long {
+			break
+		}
+		for i := 0; i < u.iters; i++ {
+			l2[i].write(a[i])
+		}
+		if len(msg) == 0 {
+			break
+		}
+	}
+	for i := 0; i < u.iters; i++ {
+		b := uint128{lo: a[i]}
+		if long {
+			b = l2[i].sum()
+		}
+		y[i] = l3(&u.l3Key1[i], b) ^ u.l3Key2[i]
+	}
+	return y
+}
+
+// l1 computes the L1 hash of a single chunk, i.e. its NH hash plus its length
+// in bits, for each iteration.
+func (u *UMAC) l1(out *[maxIters]uint64, chunk []byte) {
+	bitLen := uint64(8 * len(chunk))
+	if len(chunk) == 0 || len(chunk)%32 != 0 {
+		// pad with zeros to a multiple of 32 bytes, and to at least 32 bytes
+		n := (len(chunk) + 31) / 32 * 32
+		if n == 0 {
+			n = 32
+		}
+		var buf [chunkSize]byte
+		copy(buf[:], chunk)
+		chunk = buf[:n]
+	}
+	*out = [maxIters]uint64{}
+	nhSum(out, chunk, u.l1Key[:], u.iters)
+	for i := range out {
+		out[i] += bitLen
+	}
+}
+
+// nhSum adds the NH hash of m, which must be a multiple of 32 bytes, to each of
+// the first iters sums. The key for iteration i starts 4 words after the key
+// for iteration i-1.
+func nhSum(sums *[maxIters]uint64, m []byte, key []uint32, iters int) {
+	for len(m) >= 32 {
+		m0 := binary.LittleEndian.Uint32(m[0:])
+		m1 := binary.LittleEndian.Uint32(m[4:])
+		m2 := binary.LittleEndian.Uint32(m[8:])
+		m3 := binary.LittleEndian.Uint32(m[12:])
+		m4 := binary.LittleEndian.Uint32(m[16:])
+		m5 := binary.LittleEndian.Uint32(m[20:])
+		m6 := binary.LittleEndian.Uint32(m[24:])
+		m7 := binary.LittleEndian.Uint32(m[28:])
+		for i := 0; i < iters; i++ {
+			k := key[4*i:][:8]
+			sums[i] += uint64(m0+k[0])*uint64(m4+k[4]) +
+				uint64(m1+k[1])*uint64(m5+k[5]) +
+				uint64(m2+k[2])*uint64(m6+k[6]) +
+				uint64(m3+k[3])*uint64(m7+k[7])
+		}
+		m = m[32:]
+		key = key[8:]
+	}
+}
+
+// p36 is the prime modulus of the L3 hash.
+const p36 = 1<<36 - 5
+
+// l3 computes the L3 hash of b, without the final XOR with the second key.
+func l3(key *[8]uint64, b uint128) uint32 {
+	var y uint64
+	for i := 0; i < 4; i++ {
+		y += uint64(uint16(b.hi>>(48-16*i))) * key[i]
+		y += uint64(uint16(b.lo>>(48-16*i))) * key[4+i]
+	}
+	return uint32(y % p36)
+}
@@ -0,0 +1,198 @@
+package umac
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func testTags(t *testing.T, name string, key, nonce, msg []byte, tags []string) {
+	t.Helper()
+	for i, tag := range tags {
+		tagSize := 4 * (i + 1)
+		u := New(key, tagSize)
+		exp, _ := hex.DecodeString(tag)
+		if got := u.Sum(nil, nonce, msg); !bytes.Equal(got, exp) {
+			t.Errorf("%v, UMAC-%v: expected %X, got %X", name, 8*tagSize, exp, got)
+		} else if !u.Verify(nonce, msg, exp) {
+			t.Errorf("%v, UMAC-%v: Verify rejected valid tag", name, 8*tagSize)
+		}
+	}
+}
+
+// rfcKey and rfcNonce are the key and nonce of the RFC 4418 test vectors.
+var rfcKey, rfcNonce = []byte("abcdefghijklmnop"), []byte("bcdefghi")
+
+func TestRFC4418(t *testing.T) {
+	// RFC 4418, Appendix
+	tests := []struct {
+		pattern string
+		n       int
+		tags    []string // UMAC-32, UMAC-64, UMAC-96
+	}{
+		{"", 0, []string{"113145FB", "6E155FAD26900BE1", "32FEDB100C79AD58F07FF764"}},
+		{"a", 3, []string{"3B91D102", "44B5CB542F220104", "185E4FE905CBA7BD85E4C2DC"}},
+		{"a", 1 << 10, []string{"599B350B", "26BF2F5D60118BD9", "7A54ABE04AF82D60FB298C3C"}},
+		{"a", 1 << 15, []string{"58DCF532", "27F8EF643B0D118D", "7B136BD911E4B734286EF2BE"}},
+		{"a", 1 << 20, []string{"DB6364D1", "A4477E87E9F55853", "F8ACFA3AC31CFEEA047F7B11"}},
+		{"a", 1 << 25, []string{"85EE5CAE", "FACA46F856E9B45F", "A621C2457C0012E64F3FDAE9"}},
+		{"abc", 1, []string{"ABF3A3A0", "D4D7B9F6BD4FBFCF", "883C3D4B97A61976FFCF2323"}},
+		{"abc", 500, []string{"ABEB3C8B", "D4CF26DDEFD5C01A", "8824A260C53C66A36C9260A6"}},
+	}
+	for _, test := range tests {
+		if testing.Short() && len(test.pattern)*test.n > 1<<20 {
+			continue
+		}
+		msg := []byte(strings.Repeat(test.pattern, test.n))
+		testTags(t, fmt.Sprintf("%q*%v", test.pattern, test.n), rfcKey, rfcNonce, msg, test.tags)
+	}
+}
+
+func TestNettle(t *testing.T) {
+	// tags computed with Nettle's UMAC implementation: UMAC-128 for the RFC
+	// 4418 inputs, which the RFC does not list, and every tag size for message
+	// lengths around the NH padding, chunk, and L2 boundaries
+	rfc := []struct {
+		pattern string
+		n       int
+		tag     string
+	}{
+		{"", 0, "32FEDB100C79AD58F07FF7643CC60465"},
+		{"a", 3, "185E4FE905CBA7BD85E4C2DC3D117D8D"},
+		{"a", 1 << 10, "7A54ABE04AF82D60FB298C3CBD195BCB"},
+		{"a", 1 << 15, "7B136BD911E4B734286EF2BE501F2C3C"},
+		{"a", 1 << 20, "F8ACFA3AC31CFEEA047F7B115B03BEF5"},
+		{"a", 1 << 25, "A621C2457C0012E64F3FDAE9E7E1870C"},
+		{"abc", 1, "883C3D4B97A61976FFCF232308CBA5A5"},
+		{"abc", 500, "8824A260C53C66A36C9260A62CB83AA1"},
+	}
+	for _, test := range rfc {
+		if testing.Short() && len(test.pattern)*test.n > 1<<20 {
+			continue
+		}
+		msg := []byte(strings.Repeat(test.pattern, test.n))
+		u := New(rfcKey, 16)
+		exp, _ := hex.DecodeString(test.tag)
+		if got := u.Sum(nil, rfcNonce, msg); !bytes.Equal(got, exp) {
+			t.Errorf("%q*%v, UMAC-128: expected %X, got %X", test.pattern, test.n, exp, got)
+		}
+	}
+
+	// byte i of each message is byte(7*i + i>>8), and each message has a
+	// nonce of a different length, whose byte i is 0xA0 + 5*i
+	tests := []struct {
+		length int
+		tags   []string
+	}{
+		{0, []string{"2826529F", "2826529F2E76AE9B", "2826529F2E76AE9B97FC6801", "2826529F2E76AE9B97FC6801AEA2EFFA"}},
+		{1, []string{"1F7CAA3C", "01E887C1DE40E39E", "F61AAD3812C546742FB3B212", "F61AAD3812C546742FB3B212DC2B1391"}},
+		{31, []string{"A77778EB", "52D4647EA2C52D19", "52D4647EA2C52D19B4B1CDF0", "52D4647EA2C52D19B4B1CDF0E09D22B7"}},
+		{32, []string{"5EEFCB9C", "2E05D47070FD03ED", "835DB3CA6ED5526958700B09", "835DB3CA6ED5526958700B096BC169EF"}},
+		{33, []string{"306E1FA1", "306E1FA159AD2959", "306E1FA159AD2959811C4AE0", "306E1FA159AD2959811C4AE0C3DFA5A5"}},
+		{100, []string{"E1B5E489", "7D4DD20C98A8AF29", "AAC9DC40695F1EEEF1DACE39", "AAC9DC40695F1EEEF1DACE39F7010062"}},
+		{1023, []string{"6CB11DC0", "D21AABAD5528F8D1", "D21AABAD5528F8D1BBF2BD29", "D21AABAD5528F8D1BBF2BD290E3BD330"}},
+		{1024, []string{"72367D8D", "D585A79AE6D91BEB", "CC14C6FADCFE91F9394ED553", "CC14C6FADCFE91F9394ED553C385C2FD"}},
+		{1025, []string{"82FD0D61", "82FD0D61D3D9534C", "82FD0D61D3D9534C4FDFA56C", "82FD0D61D3D9534C4FDFA56CF4C0AA71"}},
+		{2047, []string{"403AA755", "9E4F52944E2BD128", "62BA7623F31371083802CC5F", "62BA7623F31371083802CC5F5C6A5139"}},
+		{2048, []string{"09037859", "0872CEB8AC443DC4", "0872CEB8AC443DC47F2EFC71", "0872CEB8AC443DC47F2EFC713CF59D59"}},
+		{4000, []string{"F6EC7351", "F0C60375B6C57C0F", "257096A6DE40DEABFC74A9D0", "257096A6DE40DEABFC74A9D0C7FED2BA"}},
+		{1 << 24, []string{"E32E3300", "E32E3300FF9D7800", "E32E3300FF9D7800647113B0", "E32E3300FF9D7800647113B0D6DBBA97"}},
+		{1<<24 + 1024, []string{"C7225D72", "6E115C46F46657A5", "144B0E22F4B4748BD56FA6D1", "144B0E22F4B4748BD56FA6D15D729CD4"}},
+		{1<<24 + 2048, []string{"F87D9EE9", "1444AD0ACA9DB979", "1444AD0ACA9DB9792FE47A79", "1444AD0ACA9DB9792FE47A79FF282F24"}},
+		{1<<24 + 3000, []string{"A9AF8FB4", "7D4F95396E44587C", "58743A7EB39160BEE1EECE5D", "58743A7EB39160BEE1EECE5D0D169128"}},
+	}
+	msg := make([]byte, 1<<24+3000)
+	for i := range msg {
+		msg[i] = byte(7*i + i>>8)
+	}
+	var nonce [16]byte
+	for i := range nonce {
+		nonce[i] = byte(0xA0 + 5*i)
+	}
+	for i, test := range tests {
+		if testing.Short() && test.length > 1<<20 {
+			continue
+		}
+		testTags(t, fmt.Sprintf("%v-byte message", test.length), rfcKey, nonce[:i+1], msg[:test.length], test.tags)
+	}
+}
+
+func TestPoly128(t *testing.T) {
+	toBig := func(x uint128) *big.Int {
+		b := new(big.Int).SetUint64(x.hi)
+		b.Lsh(b, 64)
+		return b.Add(b, new(big.Int).SetUint64(x.lo))
+	}
+	p := toBig(p128)
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 10000; i++ {
+		k := uint128{r.Uint64() & 0x01FFFFFF01FFFFFF, r.Uint64() & 0x01FFFFFF01FFFFFF}
+		y := uint128{r.Uint64(), r.Uint64()}
+		if i%2 == 0 {
+			// near the modulus
+			y = uint128{p128.hi, p128.lo - 1 - uint64(r.Intn(8))}
+		}
+		m := uint128{r.Uint64(), r.Uint64()}
+		exp := new(big.Int).Mul(toBig(k), toBig(y))
+		exp.Add(exp, toBig(m)).Mod(exp, p)
+		if got := toBig(poly128(y, k, m)); got.Cmp(exp) != 0 {
+			t.Fatalf("(%x * %x + %x) mod p128: expected %x, got %x", k, y, m, exp, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	u := New(make([]byte, KeySize), 8)
+	nonce := []byte("nonce")
+	msg := []byte("message")
+	tag := u.Sum(nil, nonce, msg)
+	if !u.Verify(nonce, msg, tag) {
+		t.Fatal("Verify rejected valid tag")
+	}
+	tag[0] ^= 1
+	if u.Verify(nonce, msg, tag) {
+		t.Fatal("Verify accepted invalid tag")
+	} else if u.Verify(nonce, msg, tag[:4]) {
+		t.Fatal("Verify accepted truncated tag")
+	}
+}
+
+func TestPanics(t *testing.T) {
+	for _, fn := range []func(){
+		func() { New(make([]byte, 32), 16) },
+		func() { New(make([]byte, KeySize), 6) },
+		func() { New(make([]byte, KeySize), 8).Sum(nil, nil, nil) },
+		func() { New(make([]byte, KeySize), 8).Sum(nil, make([]byte, 17), nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func BenchmarkUMAC(b *testing.B) {
+	for _, tagSize := range []int{8, 16} {
+		u := New(make([]byte, KeySize), tagSize)
+		nonce := make([]byte, 8)
+		for _, size := range []int{64, 1024, 8192} {
+			msg := make([]byte, size)
+			b.Run(fmt.Sprintf("UMAC-%v/%v", 8*tagSize, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				var tag [16]byte
+				for i := 0; i < b.N; i++ {
+					u.Sum(tag[:0], nonce, msg)
+				}
+			})
+		}
+	}
+}